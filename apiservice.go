@@ -0,0 +1,80 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"encoding/base64"
+	"k8s.io/apimachinery/pkg/api/equality"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// apiServiceResource is apiregistration.k8s.io/v1 APIService, handled as unstructured
+// so the aggregator clientset is not needed.
+var apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+func (c *CertWebHook) patchAPIService(name string, caBundle string) error {
+	apiService, err := c.dynamicClient.Resource(apiServiceResource).Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	orig := apiService.DeepCopy()
+	if err := c.injectAPIService(apiService, caBundle); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(orig, apiService) {
+		return nil
+	}
+	_, err = c.dynamicClient.Resource(apiServiceResource).Update(context.TODO(), apiService, v1.UpdateOptions{})
+	return err
+}
+
+// injectAPIService points apiService at the service with caBundle, which also turns off insecureSkipTLSVerify.
+func (c *CertWebHook) injectAPIService(apiService *unstructured.Unstructured, caBundle string) error {
+	if err := unstructured.SetNestedField(apiService.Object, c.ServiceName, "spec", "service", "name"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(apiService.Object, c.Namespace, "spec", "service", "namespace"); err != nil {
+		return err
+	}
+	unstructured.RemoveNestedField(apiService.Object, "spec", "insecureSkipTLSVerify")
+	return unstructured.SetNestedField(apiService.Object, base64.StdEncoding.EncodeToString([]byte(caBundle)), "spec", "caBundle")
+}
+
+// apiServiceCABundle returns the decoded spec.caBundle of apiService.
+func apiServiceCABundle(apiService *unstructured.Unstructured) []byte {
+	encoded, _, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle")
+	caBundle, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return []byte(encoded)
+	}
+	return caBundle
+}
+
+func (c *CertWebHook) resetAPIService(name string) error {
+	apiService, err := c.dynamicClient.Resource(apiServiceResource).Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(apiService.Object, "spec", "caBundle"); !found {
+		return nil
+	}
+	unstructured.RemoveNestedField(apiService.Object, "spec", "caBundle")
+	_, err = c.dynamicClient.Resource(apiServiceResource).Update(context.TODO(), apiService, v1.UpdateOptions{})
+	return ignoreNotFound(err)
+}
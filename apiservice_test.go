@@ -0,0 +1,135 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestCertWebHook_GeneratorAPIServiceAndCRD(t *testing.T) {
+	port := int32(8443)
+	crdClient := apiextensionsfake.NewSimpleClientset(&apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: v1.ObjectMeta{Name: "crontabs.cuisongliu.com"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextensionsv1.CustomResourceConversion{
+				Strategy: apiextensionsv1.WebhookConverter,
+				Webhook: &apiextensionsv1.WebhookConversion{
+					ClientConfig: &apiextensionsv1.WebhookClientConfig{
+						Service: &apiextensionsv1.ServiceReference{Name: "old", Namespace: "old", Port: &port},
+					},
+				},
+			},
+		},
+	})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": "v1beta1.metrics.cuisongliu.com"},
+		"spec": map[string]interface{}{
+			"group":                 "metrics.cuisongliu.com",
+			"version":               "v1beta1",
+			"insecureSkipTLSVerify": true,
+		},
+	}})
+	cli := fake.NewSimpleClientset()
+	c := &CertWebHook{
+		Subject:     []string{"www.cuisongliu.com"},
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook: []WebHook{
+			{CRDName: "crontabs.cuisongliu.com"},
+			{APIServiceName: "v1beta1.metrics.cuisongliu.com"},
+		},
+		client:        cli,
+		crdClient:     crdClient,
+		dynamicClient: dynamicClient,
+	}
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crd, err := crdClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), "crontabs.cuisongliu.com", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig := crd.Spec.Conversion.Webhook.ClientConfig
+	if !bytes.Equal(clientConfig.CABundle, secret.Data[caBundleKey]) {
+		t.Error("crd caBundle is not the self-signed CA")
+	}
+	if clientConfig.Service.Name != "service" || clientConfig.Service.Namespace != "default" || *clientConfig.Service.Port != port {
+		t.Errorf("got crd service %+v", clientConfig.Service)
+	}
+
+	apiService, err := dynamicClient.Resource(apiServiceResource).Get(context.TODO(), "v1beta1.metrics.cuisongliu.com", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(apiServiceCABundle(apiService), secret.Data[caBundleKey]) {
+		t.Error("apiservice caBundle is not the self-signed CA")
+	}
+	service, _, _ := unstructured.NestedStringMap(apiService.Object, "spec", "service")
+	if service["name"] != "service" || service["namespace"] != "default" {
+		t.Errorf("got apiservice service %v", service)
+	}
+	if _, found, _ := unstructured.NestedBool(apiService.Object, "spec", "insecureSkipTLSVerify"); found {
+		t.Error("insecureSkipTLSVerify is still set")
+	}
+
+	plan, err := c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cp := range plan.Configurations {
+		if len(cp.Entries) != 0 {
+			t.Errorf("got changes %+v for %s after Generator", cp.Entries, cp.Name)
+		}
+	}
+
+	// deleting the webhooks only resets the APIService
+	if err := c.Cleanup(DeleteWebHooks); err != nil {
+		t.Fatal(err)
+	}
+	apiService, err = dynamicClient.Resource(apiServiceResource).Get(context.TODO(), "v1beta1.metrics.cuisongliu.com", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("apiservice was deleted: %v", err)
+	}
+	if _, found, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle"); found {
+		t.Error("apiservice caBundle was not reset")
+	}
+	if err := c.Cleanup(DeleteAPIServices); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dynamicClient.Resource(apiServiceResource).Get(context.TODO(), "v1beta1.metrics.cuisongliu.com", v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("apiservice is not deleted: %v", err)
+	}
+}
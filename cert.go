@@ -18,6 +18,7 @@ package webhook
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -26,12 +27,37 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"math"
+	"math/big"
 	"net"
+	"time"
+)
+
+const (
+	// caValidity is the lifetime of self-signed CA certificates.
+	caValidity = 10 * 365 * 24 * time.Hour
+	// certValidity is the lifetime of serving certificates signed by a self-signed CA.
+	certValidity = 365 * 24 * time.Hour
+)
+
+// KeyAlgorithm is the algorithm and size of generated private keys.
+type KeyAlgorithm string
+
+const (
+	RSA2048   KeyAlgorithm = "RSA2048"
+	RSA3072   KeyAlgorithm = "RSA3072"
+	RSA4096   KeyAlgorithm = "RSA4096"
+	ECDSAP256 KeyAlgorithm = "ECDSAP256"
+	ECDSAP384 KeyAlgorithm = "ECDSAP384"
+	Ed25519   KeyAlgorithm = "Ed25519"
 )
 
 type CertConfig struct {
 	CommonName   string
 	Organization []string
+	// KeyAlgorithm is the algorithm of the generated key, RSA2048 by default.
+	KeyAlgorithm KeyAlgorithm
 	// AltNames contains the domain names and IP addresses that will be added
 	// to the API Server's x509 certificate SubAltNames field. The values will
 	// be passed directly to the x509.Certificate object.
@@ -50,15 +76,35 @@ func NewPrivateKey(keyType x509.PublicKeyAlgorithm) (crypto.Signer, error) {
 	return rsa.GenerateKey(rand.Reader, 2048)
 }
 
+// NewKey creates a private key of the algorithm, an RSA 2048 key when it is empty.
+func NewKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case "", RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %s", algorithm)
+}
+
 func NewSigned(cfg CertConfig) (csr, keyPEM []byte, err error) {
-	key, err := NewPrivateKey(x509.RSA)
+	key, err := NewKey(cfg.KeyAlgorithm)
 	if err != nil {
 		return nil, nil, fmt.Errorf("new signed private failed %s", err)
 	}
-	pk := x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey))
-	keyPEM = pem.EncodeToMemory(&pem.Block{
-		Type: "RSA PRIVATE KEY", Bytes: pk,
-	})
+	keyPEM, err = encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
 	_, csr, err = GenerateCSR(cfg, key)
 
 	csr = pem.EncodeToMemory(&pem.Block{
@@ -106,16 +152,164 @@ func GenerateCSR(cfg CertConfig, key crypto.Signer) (*x509.CertificateRequest, [
 	return r1, certDERBytes, r3
 }
 
-func (c *CertWebHook) generateTLS() (csr []byte, key []byte, err error) {
+// NewSelfSignedCA creates a self-signed CA certificate valid for caValidity, it returns the PEM encoded certificate and key.
+func NewSelfSignedCA(cfg CertConfig) (certPEM, keyPEM []byte, err error) {
+	key, err := NewKey(cfg.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new ca private key failed %s", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(caValidity).UTC(),
+		KeyUsage:              keyUsage(key) | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new ca certificate failed %s", err)
+	}
+	keyPEM, err = encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// NewSignedCert creates a serving certificate for cfg valid for certValidity and signs it with the PEM encoded CA,
+// it returns the PEM encoded certificate and key.
+func NewSignedCert(cfg CertConfig, caCertPEM, caKeyPEM []byte) (certPEM, keyPEM []byte, err error) {
+	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := NewKey(cfg.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new signed private failed %s", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		DNSNames:    cfg.AltNames.DNSNames,
+		IPAddresses: cfg.AltNames.IPs,
+		NotBefore:   now.UTC(),
+		NotAfter:    now.Add(certValidity).UTC(),
+		KeyUsage:    keyUsage(key),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new signed certificate failed %s", err)
+	}
+	keyPEM, err = encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, fmt.Errorf("new serial number failed %s", err)
+	}
+	return serial, nil
+}
+
+// keyUsage returns the usages of certificates of key, only RSA keys encipher keys.
+func keyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// encodePrivateKey encodes key as a PKCS#8 PEM block.
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key failed %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParseCertificate parses the first certificate of a PEM encoded bundle.
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseCA(caCertPEM, caKeyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	caCert, err := ParseCertificate(caCertPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca certificate failed %s", err)
+	}
+	caKey, err := parsePrivateKey(caKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca private key failed %s", err)
+	}
+	return caCert, caKey, nil
+}
+
+func (c *CertWebHook) certConfig() CertConfig {
 	host := fmt.Sprintf("%s.%s", c.ServiceName, c.Namespace)
 	dnsNames := []string{
 		host,
 		fmt.Sprintf("%s.svc", host),
 		fmt.Sprintf("%s.svc.cluster.local", host),
 	}
+	commonName, organization := host, c.Subject
+	if !c.SelfSigned && c.SignerName == certificatesv1.KubeletServingSignerName {
+		// the kubelet-serving signer only issues certificates of nodes
+		commonName, organization = "system:node:"+host, []string{"system:nodes"}
+	}
 	cfg := CertConfig{
-		CommonName:   host,
-		Organization: c.Subject,
+		CommonName:   commonName,
+		Organization: organization,
+		KeyAlgorithm: c.KeyAlgorithm,
 		AltNames: struct {
 			DNSNames []string
 			IPs      []net.IP
@@ -123,6 +317,28 @@ func (c *CertWebHook) generateTLS() (csr []byte, key []byte, err error) {
 			DNSNames: dnsNames,
 		},
 	}
-	csr, key, err = NewSigned(cfg)
+	return cfg
+}
+
+func (c *CertWebHook) generateTLS() (csr []byte, key []byte, err error) {
+	csr, key, err = NewSigned(c.certConfig())
+	return
+}
+
+func (c *CertWebHook) caConfig() CertConfig {
+	return CertConfig{
+		CommonName:   fmt.Sprintf("%s-ca", c.ServiceName),
+		Organization: c.Subject,
+		KeyAlgorithm: c.KeyAlgorithm,
+	}
+}
+
+// generateSelfSignedTLS creates a CA and a serving certificate signed by it.
+func (c *CertWebHook) generateSelfSignedTLS() (caCert, caKey, cert, key []byte, err error) {
+	caCert, caKey, err = NewSelfSignedCA(c.caConfig())
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	cert, key, err = NewSignedCert(c.certConfig(), caCert, caKey)
 	return
 }
@@ -16,6 +16,11 @@ limitations under the License.
 package webhook
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"net"
 	"testing"
 )
@@ -95,3 +100,97 @@ func TestNewSignedCa(t *testing.T) {
 		})
 	}
 }
+
+func TestNewSignedCert(t *testing.T) {
+	c := &CertWebHook{
+		Subject:     []string{"cuisongliu CN"},
+		Namespace:   "default",
+		ServiceName: "svc",
+	}
+	caCert, caKey, cert, key, err := c.generateSelfSignedTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		t.Fatalf("serving certificate and key do not match: %v", err)
+	}
+	if _, err := parsePrivateKey(caKey); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		t.Fatal("ca certificate is not PEM encoded")
+	}
+	serving, err := ParseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"svc.default.svc", "svc.default.svc.cluster.local"} {
+		if _, err := serving.Verify(x509.VerifyOptions{DNSName: host, Roots: pool}); err != nil {
+			t.Errorf("verify %s: %v", host, err)
+		}
+	}
+}
+
+func TestKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		algorithm KeyAlgorithm
+		want      x509.PublicKeyAlgorithm
+		wantBits  int
+	}{
+		{algorithm: "", want: x509.RSA, wantBits: 2048},
+		{algorithm: RSA3072, want: x509.RSA, wantBits: 3072},
+		{algorithm: RSA4096, want: x509.RSA, wantBits: 4096},
+		{algorithm: ECDSAP256, want: x509.ECDSA, wantBits: 256},
+		{algorithm: ECDSAP384, want: x509.ECDSA, wantBits: 384},
+		{algorithm: Ed25519, want: x509.Ed25519},
+	}
+	for _, tt := range tests {
+		name := string(tt.algorithm)
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			c := &CertWebHook{Namespace: "default", ServiceName: "svc", KeyAlgorithm: tt.algorithm}
+			csrPEM, keyPEM, err := c.generateTLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "PRIVATE KEY" {
+				t.Errorf("key is not PKCS#8 PEM encoded")
+			}
+			block, _ := pem.Decode(csrPEM)
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if csr.PublicKeyAlgorithm != tt.want {
+				t.Errorf("got csr key algorithm %s, want %s", csr.PublicKeyAlgorithm, tt.want)
+			}
+			switch k := csr.PublicKey.(type) {
+			case *rsa.PublicKey:
+				if k.N.BitLen() != tt.wantBits {
+					t.Errorf("got %d bits, want %d", k.N.BitLen(), tt.wantBits)
+				}
+			case *ecdsa.PublicKey:
+				if k.Curve.Params().BitSize != tt.wantBits {
+					t.Errorf("got curve %d bits, want %d", k.Curve.Params().BitSize, tt.wantBits)
+				}
+			}
+
+			caCert, _, cert, key, err := c.generateSelfSignedTLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tls.X509KeyPair(cert, key); err != nil {
+				t.Fatalf("serving certificate and key do not match: %v", err)
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(caCert)
+			serving, _ := ParseCertificate(cert)
+			if _, err := serving.Verify(x509.VerifyOptions{DNSName: "svc.default.svc", Roots: pool}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
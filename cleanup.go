@@ -0,0 +1,181 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"os"
+	"path"
+)
+
+// WebHookCleanup is what Cleanup does with the configured webhook configurations.
+type WebHookCleanup string
+
+const (
+	// KeepWebHooks leaves the webhook configurations as they are.
+	KeepWebHooks WebHookCleanup = "keep"
+	// ResetWebHooks removes the caBundle injected into the webhook configurations and CRDs.
+	ResetWebHooks WebHookCleanup = "reset"
+	// DeleteWebHooks deletes the webhook configurations and resets the CRDs and APIServices, which usually
+	// belong to the install of their API and are not deleted.
+	DeleteWebHooks WebHookCleanup = "delete"
+	// DeleteAPIServices deletes the APIServices too, which makes their API group unavailable.
+	DeleteAPIServices WebHookCleanup = "delete-apiservices"
+)
+
+// Cleanup deletes the Secret, the CSR, the Lease and the files in CertDir, then keeps, resets or deletes the
+// configured webhook configurations. Resources already gone are skipped, so it can run again, e.g. from a
+// Helm pre-delete hook or a finalizer, and it tries every step before returning the errors.
+func (c *CertWebHook) Cleanup(webhooks WebHookCleanup) error {
+	var errs []error
+	switch webhooks {
+	case KeepWebHooks, "":
+	case ResetWebHooks, DeleteWebHooks, DeleteAPIServices:
+		errs = append(errs, c.cleanupWebHooks(webhooks != ResetWebHooks, webhooks == DeleteAPIServices)...)
+	default:
+		return fmt.Errorf("unknown webhook cleanup %s", webhooks)
+	}
+
+	err := c.client.CoreV1().Secrets(c.Namespace).Delete(context.TODO(), c.SecretName, v1.DeleteOptions{})
+	errs = append(errs, ignoreNotFound(err))
+	if !c.SelfSigned {
+		errs = append(errs, c.deleteCsr())
+	}
+	err = c.client.CoordinationV1().Leases(c.Namespace).Delete(context.TODO(), c.leaseName(), v1.DeleteOptions{})
+	errs = append(errs, ignoreNotFound(err))
+	if target, err := os.Readlink(path.Join(c.CertDir, tlsDataDir)); err == nil {
+		errs = append(errs, os.RemoveAll(path.Join(c.CertDir, target)))
+	}
+	for _, name := range []string{"tls.crt", "tls.key", tlsDataDir} {
+		if err := os.Remove(path.Join(c.CertDir, name)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	c.serving.mu.Lock()
+	c.serving.cert = nil
+	c.serving.mu.Unlock()
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *CertWebHook) deleteCsr() error {
+	v1Available, err := c.csrV1Available()
+	if err != nil {
+		return err
+	}
+	if v1Available {
+		return ignoreNotFound(c.client.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), c.CsrName, v1.DeleteOptions{}))
+	}
+	return ignoreNotFound(c.client.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), c.CsrName, v1.DeleteOptions{}))
+}
+
+// cleanupWebHooks deletes the webhook configurations, and the APIServices with deleteAPIServices,
+// or removes their caBundle. CRDs only get their caBundle removed.
+func (c *CertWebHook) cleanupWebHooks(deleteConfigurations, deleteAPIServices bool) []error {
+	var errs []error
+	for _, wk := range c.WebHook {
+		if wk.ValidatingName != "" {
+			if deleteConfigurations {
+				err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(context.TODO(), wk.ValidatingName, v1.DeleteOptions{})
+				errs = append(errs, ignoreNotFound(err))
+			} else {
+				errs = append(errs, c.resetValidating(wk.ValidatingName))
+			}
+		}
+		if wk.MutatingName != "" {
+			if deleteConfigurations {
+				err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(context.TODO(), wk.MutatingName, v1.DeleteOptions{})
+				errs = append(errs, ignoreNotFound(err))
+			} else {
+				errs = append(errs, c.resetMutating(wk.MutatingName))
+			}
+		}
+		if wk.CRDName != "" {
+			errs = append(errs, c.resetCRD(wk.CRDName))
+		}
+		if wk.APIServiceName != "" {
+			if deleteAPIServices {
+				err := c.dynamicClient.Resource(apiServiceResource).Delete(context.TODO(), wk.APIServiceName, v1.DeleteOptions{})
+				errs = append(errs, ignoreNotFound(err))
+			} else {
+				errs = append(errs, c.resetAPIService(wk.APIServiceName))
+			}
+		}
+	}
+	return errs
+}
+
+func (c *CertWebHook) resetValidating(name string) error {
+	vwebhook, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	changed := false
+	for i := range vwebhook.Webhooks {
+		if len(vwebhook.Webhooks[i].ClientConfig.CABundle) != 0 {
+			vwebhook.Webhooks[i].ClientConfig.CABundle = nil
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), vwebhook, v1.UpdateOptions{})
+	return ignoreNotFound(err)
+}
+
+func (c *CertWebHook) resetMutating(name string) error {
+	mwebhook, err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	changed := false
+	for i := range mwebhook.Webhooks {
+		if len(mwebhook.Webhooks[i].ClientConfig.CABundle) != 0 {
+			mwebhook.Webhooks[i].ClientConfig.CABundle = nil
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), mwebhook, v1.UpdateOptions{})
+	return ignoreNotFound(err)
+}
+
+func (c *CertWebHook) resetCRD(name string) error {
+	crd, err := c.crdClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), name, v1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	conversion := crd.Spec.Conversion
+	if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil || len(conversion.Webhook.ClientConfig.CABundle) == 0 {
+		return nil
+	}
+	conversion.Webhook.ClientConfig.CABundle = nil
+	_, err = c.crdClient.ApiextensionsV1().CustomResourceDefinitions().Update(context.TODO(), crd, v1.UpdateOptions{})
+	return ignoreNotFound(err)
+}
+
+func ignoreNotFound(err error) error {
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
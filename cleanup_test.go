@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCertWebHook_Cleanup(t *testing.T) {
+	tests := []struct {
+		name        string
+		webhooks    WebHookCleanup
+		wantDeleted bool
+		wantCA      bool
+	}{
+		{name: "keep", webhooks: KeepWebHooks, wantCA: true},
+		{name: "reset", webhooks: ResetWebHooks},
+		{name: "delete", webhooks: DeleteWebHooks, wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := newPlanClient()
+			c := &CertWebHook{
+				CertDir:     t.TempDir(),
+				SelfSigned:  true,
+				Namespace:   "default",
+				ServiceName: "service",
+				SecretName:  "webhook-cert",
+				WebHook:     []WebHook{{ValidatingName: "validating-cfg"}, {MutatingName: "mutating-cfg"}},
+				client:      cli,
+			}
+			if err := c.Bootstrap(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			// twice, as a retried hook would
+			for i := 0; i < 2; i++ {
+				if err := c.Cleanup(tt.webhooks); err != nil {
+					t.Fatalf("cleanup %d: %v", i, err)
+				}
+			}
+
+			if _, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{}); !errors.IsNotFound(err) {
+				t.Errorf("secret is not deleted: %v", err)
+			}
+			if _, err := cli.CoordinationV1().Leases("default").Get(context.TODO(), "webhook-cert-lock", v1.GetOptions{}); !errors.IsNotFound(err) {
+				t.Errorf("lease is not deleted: %v", err)
+			}
+			if files, _ := ioutil.ReadDir(c.CertDir); len(files) != 0 {
+				t.Errorf("got %d files left in CertDir", len(files))
+			}
+			if _, err := c.GetCertificate(nil); err == nil {
+				t.Error("certificate is still served")
+			}
+			vwebhook, err := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+			if tt.wantDeleted {
+				if !errors.IsNotFound(err) {
+					t.Errorf("validating configuration is not deleted: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasCA := len(vwebhook.Webhooks[0].ClientConfig.CABundle) != 0; hasCA != tt.wantCA {
+				t.Errorf("got caBundle %v, want %v", hasCA, tt.wantCA)
+			}
+		})
+	}
+}
+
+func TestCertWebHook_CleanupCsr(t *testing.T) {
+	cli := newPlanClient()
+	cli.Resources = []*v1.APIResourceList{{GroupVersion: "certificates.k8s.io/v1"}}
+	c := &CertWebHook{CertDir: t.TempDir(), Namespace: "default", SecretName: "webhook-cert", CsrName: "webhook-csr", client: cli}
+	if err := c.Cleanup(KeepWebHooks); err != nil {
+		t.Fatal(err)
+	}
+	deletedCsr := false
+	for _, a := range cli.Actions() {
+		if a.GetVerb() == "delete" && a.GetResource().Resource == "certificatesigningrequests" && a.GetResource().Version == "v1" {
+			deletedCsr = true
+		}
+	}
+	if !deletedCsr {
+		t.Error("csr is not deleted")
+	}
+	if err := c.Cleanup("unknown"); err == nil {
+		t.Error("got no error for an unknown webhook cleanup")
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	v1 "github.com/cuisongliu/webhook"
+	"io"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"os/signal"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"syscall"
+)
+
+const usage = `webhook-cert bootstraps the serving certificates of admission webhooks.
+
+Usage:
+  webhook-cert <command> [flags]
+
+Commands:
+  init     create or reuse the certificates, patch the webhook configurations and write the cert dir
+  rotate   renew the certificates before they expire, until stopped
+  inspect  describe the certificate stored in the secret
+  cleanup  delete the secret, the csr and the cert dir files, and keep, reset or delete the webhook configurations
+
+Run "webhook-cert <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2:], os.Stdout); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "err: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// options are the flags of a command that are not CertWebHook fields.
+type options struct {
+	dryRun   bool
+	output   string
+	webhooks string
+}
+
+func run(command string, args []string, out io.Writer) error {
+	switch command {
+	case "init", "rotate", "inspect", "cleanup":
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(out, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+	w, opts, err := parseFlags(command, args)
+	if err != nil {
+		return err
+	}
+	if err := w.Init(); err != nil {
+		return err
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	switch command {
+	case "init":
+		if opts.dryRun {
+			plan, err := w.DryRun()
+			if err != nil {
+				return err
+			}
+			return plan.Write(out, opts.output)
+		}
+		if w.LeaderElection {
+			return w.Bootstrap(ctx)
+		}
+		return w.Generator()
+	case "rotate":
+		return w.Rotate(ctx)
+	case "inspect":
+		info, err := w.Inspect()
+		if err != nil {
+			return err
+		}
+		return info.Write(out, opts.output)
+	case "cleanup":
+		return w.Cleanup(v1.WebHookCleanup(opts.webhooks))
+	}
+	return nil
+}
+
+// parseFlags reads the CertWebHook from the file of the -config flag, then overrides it with the other flags.
+func parseFlags(command string, args []string) (*v1.CertWebHook, *options, error) {
+	w := &v1.CertWebHook{}
+	if path := configPath(args); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := yaml.Unmarshal(data, w); err != nil {
+			return nil, nil, fmt.Errorf("read config %s failed %s", path, err)
+		}
+	}
+
+	opts := &options{}
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.String("config", "", "YAML or JSON file with the CertWebHook fields, the other flags override it")
+	fs.Var(&listValue{list: &w.Subject}, "subject", "organizations of the certificate subject, comma separated")
+	fs.StringVar(&w.CertDir, "cert-dir", w.CertDir, "directory the tls.crt and tls.key files are written to")
+	fs.BoolVar(&w.SelfSigned, "self-signed", w.SelfSigned, "sign the certificate with a self-signed CA instead of a CSR")
+	fs.StringVar((*string)(&w.KeyAlgorithm), "key-algorithm", string(w.KeyAlgorithm), "RSA2048, RSA3072, RSA4096, ECDSAP256, ECDSAP384 or Ed25519")
+	fs.Float64Var(&w.RotateFraction, "rotate-fraction", w.RotateFraction, "fraction of the certificate lifetime after which it is renewed")
+	fs.StringVar(&w.Kubeconfig, "kubeconfig", w.Kubeconfig, "kubeconfig used instead of the in-cluster config")
+	fs.StringVar(&w.Namespace, "namespace", w.Namespace, "namespace of the secret and the webhook service")
+	fs.StringVar(&w.ServiceName, "service", w.ServiceName, "name of the webhook service")
+	fs.StringVar(&w.SecretName, "secret", w.SecretName, "name of the secret storing the certificate")
+	fs.StringVar(&w.CsrName, "csr", w.CsrName, "name of the CertificateSigningRequest")
+	fs.StringVar(&w.SignerName, "signer-name", w.SignerName, "signerName of the CertificateSigningRequest")
+	var signerCAFile string
+	fs.StringVar(&signerCAFile, "signer-ca-file", "", "PEM file with the CA of the signer, required by signers other than the legacy and kubelet-serving ones")
+	expirationSeconds := int(w.ExpirationSeconds)
+	fs.IntVar(&expirationSeconds, "expiration-seconds", expirationSeconds, "requested lifetime of the signed certificate")
+	fs.BoolVar(&w.LeaderElection, "leader-election", w.LeaderElection, "only create or renew certificates while holding the lease")
+	fs.StringVar(&w.LeaseName, "lease-name", w.LeaseName, "name of the lease locking the certificates")
+	fs.StringVar(&w.Identity, "identity", w.Identity, "holder identity of this replica in the lease")
+	var validating, mutating, crds, apiServices []string
+	fs.Var(&listValue{list: &validating}, "validating", "ValidatingWebhookConfiguration names to patch, comma separated")
+	fs.Var(&listValue{list: &mutating}, "mutating", "MutatingWebhookConfiguration names to patch, comma separated")
+	fs.Var(&listValue{list: &crds}, "crd", "CustomResourceDefinition names whose conversion webhook is patched, comma separated")
+	fs.Var(&listValue{list: &apiServices}, "apiservice", "APIService names to patch, comma separated")
+	namespaceSelect := map[string]*metav1.LabelSelector{}
+	objectSelect := map[string]*metav1.LabelSelector{}
+	fs.Var(selectorValue(namespaceSelect), "namespace-selector", "namespaceSelector of a webhook as <webhook>=<selector>, repeatable")
+	fs.Var(selectorValue(objectSelect), "object-selector", "objectSelector of a webhook as <webhook>=<selector>, repeatable")
+	switch command {
+	case "init":
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "print what would change without changing anything")
+		fs.StringVar(&opts.output, "o", v1.PlanText, "output format of -dry-run, text or json")
+	case "inspect":
+		fs.StringVar(&opts.output, "o", v1.PlanText, "output format, text or json")
+	case "cleanup":
+		fs.StringVar(&opts.webhooks, "webhooks", string(v1.KeepWebHooks), "keep, reset or delete the webhook configurations, delete-apiservices deletes the APIServices too")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	w.ExpirationSeconds = int32(expirationSeconds)
+	if signerCAFile != "" {
+		data, err := ioutil.ReadFile(signerCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		w.SignerCABundle = string(data)
+	}
+
+	if len(validating) != 0 || len(mutating) != 0 || len(crds) != 0 || len(apiServices) != 0 {
+		w.WebHook = nil
+		for _, name := range validating {
+			w.WebHook = append(w.WebHook, v1.WebHook{ValidatingName: name})
+		}
+		for _, name := range mutating {
+			w.WebHook = append(w.WebHook, v1.WebHook{MutatingName: name})
+		}
+		for _, name := range crds {
+			w.WebHook = append(w.WebHook, v1.WebHook{CRDName: name})
+		}
+		for _, name := range apiServices {
+			w.WebHook = append(w.WebHook, v1.WebHook{APIServiceName: name})
+		}
+	}
+	// selectors are keyed by the webhook name, so every configuration can look them up
+	for i := range w.WebHook {
+		w.WebHook[i].NamespaceSelect = mergeSelectors(w.WebHook[i].NamespaceSelect, namespaceSelect)
+		w.WebHook[i].ObjectSelect = mergeSelectors(w.WebHook[i].ObjectSelect, objectSelect)
+	}
+	return w, opts, nil
+}
+
+// configPath finds the value of the -config flag before the flags are parsed.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			return ""
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+func mergeSelectors(dst, src map[string]*metav1.LabelSelector) map[string]*metav1.LabelSelector {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = map[string]*metav1.LabelSelector{}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// listValue is a comma separated, repeatable flag replacing the list of the config file.
+type listValue struct {
+	list *[]string
+	set  bool
+}
+
+func (l *listValue) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.list = append(*l.list, v)
+		}
+	}
+	return nil
+}
+
+// selectorValue is a repeatable <webhook>=<selector> flag.
+type selectorValue map[string]*metav1.LabelSelector
+
+func (s selectorValue) String() string {
+	var pairs []string
+	for k, v := range s {
+		pairs = append(pairs, k+"="+metav1.FormatLabelSelector(v))
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (s selectorValue) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("selector %q is not <webhook>=<selector>", value)
+	}
+	selector, err := metav1.ParseToLabelSelector(value[i+1:])
+	if err != nil {
+		return err
+	}
+	s[value[:i]] = selector
+	return nil
+}
+
+// signalContext is done on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/cuisongliu/webhook"
+)
+
+const config = `
+namespace: webhook-system
+serviceName: webhook-service
+secretName: webhook-cert
+selfSigned: true
+keyAlgorithm: ECDSAP256
+subject: [cuisongliu CN]
+webHook:
+  - validatingName: validating-cfg
+    namespaceSelect:
+      validating.cuisongliu.com:
+        matchLabels:
+          webhook: enabled
+`
+
+func TestParseFlags(t *testing.T) {
+	file := path.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("config file", func(t *testing.T) {
+		w, _, err := parseFlags("init", []string{"-config", file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Namespace != "webhook-system" || w.ServiceName != "webhook-service" || !w.SelfSigned || w.KeyAlgorithm != v1.ECDSAP256 {
+			t.Errorf("got %+v", w)
+		}
+		if len(w.WebHook) != 1 || w.WebHook[0].ValidatingName != "validating-cfg" ||
+			w.WebHook[0].NamespaceSelect["validating.cuisongliu.com"].MatchLabels["webhook"] != "enabled" {
+			t.Errorf("got webhooks %+v", w.WebHook)
+		}
+	})
+
+	t.Run("flags override the config file", func(t *testing.T) {
+		signerCA := path.Join(t.TempDir(), "signer-ca.crt")
+		if err := ioutil.WriteFile(signerCA, []byte("signer ca"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		w, opts, err := parseFlags("init", []string{
+			"-signer-ca-file", signerCA,
+			"--config=" + file,
+			"-namespace", "default",
+			"-subject", "a,b",
+			"-expiration-seconds", "3600",
+			"-mutating", "mutating-cfg",
+			"-object-selector", "mutating.cuisongliu.com=app in (web),tier=front",
+			"-dry-run", "-o", "json",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Namespace != "default" || w.ServiceName != "webhook-service" || w.ExpirationSeconds != 3600 {
+			t.Errorf("got %+v", w)
+		}
+		if w.SignerCABundle != "signer ca" {
+			t.Errorf("got signer ca bundle %q", w.SignerCABundle)
+		}
+		if !reflect.DeepEqual(w.Subject, []string{"a", "b"}) {
+			t.Errorf("got subject %v", w.Subject)
+		}
+		if len(w.WebHook) != 1 || w.WebHook[0].MutatingName != "mutating-cfg" {
+			t.Fatalf("got webhooks %+v", w.WebHook)
+		}
+		selector := w.WebHook[0].ObjectSelect["mutating.cuisongliu.com"]
+		if selector == nil || selector.MatchLabels["tier"] != "front" || len(selector.MatchExpressions) != 1 {
+			t.Errorf("got object selector %v", selector)
+		}
+		if !opts.dryRun || opts.output != "json" {
+			t.Errorf("got options %+v", opts)
+		}
+	})
+
+	t.Run("bad selector", func(t *testing.T) {
+		if _, _, err := parseFlags("init", []string{"-namespace-selector", "env=prod"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := parseFlags("init", []string{"-namespace-selector", "webhook=env in prod"}); err == nil {
+			t.Error("got no error for an invalid selector")
+		}
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		_, opts, err := parseFlags("cleanup", []string{"-config", file, "-webhooks", "reset"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v1.WebHookCleanup(opts.webhooks) != v1.ResetWebHooks {
+			t.Errorf("got webhooks %s", opts.webhooks)
+		}
+	})
+
+	t.Run("dry-run is an init flag", func(t *testing.T) {
+		if _, _, err := parseFlags("inspect", []string{"-dry-run"}); err == nil {
+			t.Error("got no error for -dry-run of inspect")
+		}
+	})
+}
+
+const kubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+  - name: unreachable
+    cluster:
+      server: https://127.0.0.1:1
+contexts:
+  - name: unreachable
+    context:
+      cluster: unreachable
+current-context: unreachable
+`
+
+func TestRunWithoutWebHooks(t *testing.T) {
+	file := path.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(file, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-kubeconfig", file, "-secret", "webhook-cert"}
+	for _, command := range []string{"init", "rotate"} {
+		if err := run(command, args, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "webhook") {
+			t.Errorf("%s without webhooks got error %v", command, err)
+		}
+	}
+	// inspect and cleanup need no webhooks, they fail reaching the cluster only
+	for _, command := range []string{"inspect", "cleanup"} {
+		if err := run(command, args, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+			t.Errorf("%s without webhooks got error %v", command, err)
+		}
+	}
+}
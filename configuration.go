@@ -0,0 +1,178 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fieldManager is the server-side apply field manager of the configurations built by CertWebHook.
+const fieldManager = "webhook-cert"
+
+// WebhookDefinition describes a webhook of a configuration built by CertWebHook.
+// Zero fields are left to the API server defaults.
+type WebhookDefinition struct {
+	// Name is the fully qualified name of the webhook, e.g. vpod.cuisongliu.com.
+	Name string
+	// Path is the path of the webhook on the service, e.g. /validate--v1-pod.
+	Path string
+	// Port is the port of the service, 443 by default.
+	Port  int32
+	Rules []admissionregistrationv1.RuleWithOperations
+	// FailurePolicy is Fail by default.
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// SideEffects is None by default.
+	SideEffects admissionregistrationv1.SideEffectClass
+	// TimeoutSeconds is 10 by default.
+	TimeoutSeconds int32
+	MatchPolicy    admissionregistrationv1.MatchPolicyType
+	// ReinvocationPolicy is only used by mutating webhooks.
+	ReinvocationPolicy admissionregistrationv1.ReinvocationPolicyType
+	NamespaceSelector  *v1.LabelSelector
+	ObjectSelector     *v1.LabelSelector
+	// AdmissionReviewVersions is v1 by default.
+	AdmissionReviewVersions []string
+}
+
+func (d WebhookDefinition) clientConfig() admissionregistrationv1.WebhookClientConfig {
+	service := &admissionregistrationv1.ServiceReference{}
+	if d.Path != "" {
+		path := d.Path
+		service.Path = &path
+	}
+	if d.Port != 0 {
+		port := d.Port
+		service.Port = &port
+	}
+	return admissionregistrationv1.WebhookClientConfig{Service: service}
+}
+
+func (d WebhookDefinition) sideEffects() *admissionregistrationv1.SideEffectClass {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	if d.SideEffects != "" {
+		sideEffects = d.SideEffects
+	}
+	return &sideEffects
+}
+
+func (d WebhookDefinition) admissionReviewVersions() []string {
+	if len(d.AdmissionReviewVersions) == 0 {
+		return []string{"v1"}
+	}
+	return d.AdmissionReviewVersions
+}
+
+// validatingConfiguration builds the ValidatingWebhookConfiguration described by wk.Validating.
+func (c *CertWebHook) validatingConfiguration(wk WebHook, caBundle string) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	vwebhook := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta:   v1.TypeMeta{APIVersion: admissionregistrationv1.SchemeGroupVersion.String(), Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: v1.ObjectMeta{Name: wk.ValidatingName},
+	}
+	for _, d := range wk.Validating {
+		vwebhook.Webhooks = append(vwebhook.Webhooks, admissionregistrationv1.ValidatingWebhook{
+			Name:                    d.Name,
+			ClientConfig:            d.clientConfig(),
+			Rules:                   d.Rules,
+			FailurePolicy:           failurePolicyPtr(d.FailurePolicy),
+			MatchPolicy:             matchPolicyPtr(d.MatchPolicy),
+			NamespaceSelector:       d.NamespaceSelector,
+			ObjectSelector:          d.ObjectSelector,
+			SideEffects:             d.sideEffects(),
+			TimeoutSeconds:          int32Ptr(d.TimeoutSeconds),
+			AdmissionReviewVersions: d.admissionReviewVersions(),
+		})
+	}
+	c.injectValidating(wk, vwebhook, caBundle)
+	return vwebhook
+}
+
+// mutatingConfiguration builds the MutatingWebhookConfiguration described by wk.Mutating.
+func (c *CertWebHook) mutatingConfiguration(wk WebHook, caBundle string) *admissionregistrationv1.MutatingWebhookConfiguration {
+	mwebhook := &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta:   v1.TypeMeta{APIVersion: admissionregistrationv1.SchemeGroupVersion.String(), Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: v1.ObjectMeta{Name: wk.MutatingName},
+	}
+	for _, d := range wk.Mutating {
+		w := admissionregistrationv1.MutatingWebhook{
+			Name:                    d.Name,
+			ClientConfig:            d.clientConfig(),
+			Rules:                   d.Rules,
+			FailurePolicy:           failurePolicyPtr(d.FailurePolicy),
+			MatchPolicy:             matchPolicyPtr(d.MatchPolicy),
+			NamespaceSelector:       d.NamespaceSelector,
+			ObjectSelector:          d.ObjectSelector,
+			SideEffects:             d.sideEffects(),
+			TimeoutSeconds:          int32Ptr(d.TimeoutSeconds),
+			AdmissionReviewVersions: d.admissionReviewVersions(),
+		}
+		if d.ReinvocationPolicy != "" {
+			policy := d.ReinvocationPolicy
+			w.ReinvocationPolicy = &policy
+		}
+		mwebhook.Webhooks = append(mwebhook.Webhooks, w)
+	}
+	c.injectMutating(wk, mwebhook, caBundle)
+	return mwebhook
+}
+
+// applyValidating creates or updates the ValidatingWebhookConfiguration of wk with server-side apply.
+func (c *CertWebHook) applyValidating(wk WebHook, caBundle string) error {
+	data, err := json.Marshal(c.validatingConfiguration(wk, caBundle))
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Patch(context.TODO(), wk.ValidatingName,
+		types.ApplyPatchType, data, v1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	return err
+}
+
+// applyMutating creates or updates the MutatingWebhookConfiguration of wk with server-side apply.
+func (c *CertWebHook) applyMutating(wk WebHook, caBundle string) error {
+	data, err := json.Marshal(c.mutatingConfiguration(wk, caBundle))
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Patch(context.TODO(), wk.MutatingName,
+		types.ApplyPatchType, data, v1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	return err
+}
+
+func failurePolicyPtr(policy admissionregistrationv1.FailurePolicyType) *admissionregistrationv1.FailurePolicyType {
+	if policy == "" {
+		return nil
+	}
+	return &policy
+}
+
+func matchPolicyPtr(policy admissionregistrationv1.MatchPolicyType) *admissionregistrationv1.MatchPolicyType {
+	if policy == "" {
+		return nil
+	}
+	return &policy
+}
+
+func int32Ptr(i int32) *int32 {
+	if i == 0 {
+		return nil
+	}
+	return &i
+}
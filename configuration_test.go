@@ -0,0 +1,153 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+)
+
+// applyReactor stands in for server-side apply, which the fake clientset lacks, by replacing the whole object.
+func applyReactor(cli *fake.Clientset, newObject func() runtime.Object) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := newObject()
+		if err := json.Unmarshal(patch.GetPatch(), obj); err != nil {
+			return true, nil, err
+		}
+		gvr := action.GetResource()
+		_, err := cli.Tracker().Get(gvr, "", patch.GetName())
+		switch {
+		case errors.IsNotFound(err):
+			err = cli.Tracker().Create(gvr, obj, "")
+		case err == nil:
+			err = cli.Tracker().Update(gvr, obj, "")
+		}
+		return true, obj, err
+	}
+}
+
+func TestCertWebHook_GeneratorCreatesConfigurations(t *testing.T) {
+	cli := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "mutating-cfg"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "mpod.cuisongliu.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("Cg==")},
+		}},
+	})
+	cli.PrependReactor("patch", "validatingwebhookconfigurations", applyReactor(cli, func() runtime.Object {
+		return &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	}))
+	cli.PrependReactor("patch", "mutatingwebhookconfigurations", applyReactor(cli, func() runtime.Object {
+		return &admissionregistrationv1.MutatingWebhookConfiguration{}
+	}))
+	rules := []admissionregistrationv1.RuleWithOperations{{
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+	}}
+	c := &CertWebHook{
+		Subject:     []string{"www.cuisongliu.com"},
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook: []WebHook{{
+			ValidatingName: "validating-cfg",
+			Validating: []WebhookDefinition{{
+				Name:          "vpod.cuisongliu.com",
+				Path:          "/validate--v1-pod",
+				Rules:         rules,
+				FailurePolicy: admissionregistrationv1.Ignore,
+			}},
+			MutatingName: "mutating-cfg",
+			Mutating: []WebhookDefinition{{
+				Name:               "mpod.cuisongliu.com",
+				Path:               "/mutate--v1-pod",
+				Rules:              rules,
+				TimeoutSeconds:     5,
+				ReinvocationPolicy: admissionregistrationv1.IfNeededReinvocationPolicy,
+			}},
+			ObjectSelect: map[string]*v1.LabelSelector{"vpod.cuisongliu.com": {MatchLabels: map[string]string{"webhook": "enabled"}}},
+		}},
+		client: cli,
+	}
+
+	plan, err := c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Configurations[0].Action != ConfigurationCreate || plan.Configurations[1].Action != ConfigurationApply {
+		t.Errorf("got plan %s", plan)
+	}
+
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vwebhook, err := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vw := vwebhook.Webhooks[0]
+	if !bytes.Equal(vw.ClientConfig.CABundle, secret.Data[caBundleKey]) {
+		t.Error("caBundle is not the self-signed CA")
+	}
+	if vw.ClientConfig.Service.Name != "service" || *vw.ClientConfig.Service.Path != "/validate--v1-pod" {
+		t.Errorf("got service %+v", vw.ClientConfig.Service)
+	}
+	if *vw.FailurePolicy != admissionregistrationv1.Ignore || *vw.SideEffects != admissionregistrationv1.SideEffectClassNone ||
+		vw.AdmissionReviewVersions[0] != "v1" || vw.TimeoutSeconds != nil {
+		t.Errorf("got webhook %+v", vw)
+	}
+	if vw.ObjectSelector == nil || vw.ObjectSelector.MatchLabels["webhook"] != "enabled" {
+		t.Errorf("got objectSelector %v", vw.ObjectSelector)
+	}
+	mwebhook, err := cli.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "mutating-cfg", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := mwebhook.Webhooks[0]
+	if !bytes.Equal(mw.ClientConfig.CABundle, secret.Data[caBundleKey]) || *mw.TimeoutSeconds != 5 ||
+		*mw.ReinvocationPolicy != admissionregistrationv1.IfNeededReinvocationPolicy || len(mw.Rules) != 1 {
+		t.Errorf("got webhook %+v", mw)
+	}
+
+	plan, err = c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cp := range plan.Configurations {
+		if cp.Action != ConfigurationApply || len(cp.Entries) != 0 {
+			t.Errorf("got plan %s after Generator", plan)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// SetupWithManager adds a controller to mgr watching the Secret and the configured webhook configurations,
+// CustomResourceDefinitions and APIServices. Whenever they drift, e.g. when the webhook manifests are applied
+// again or the Secret is deleted, it recreates the Secret and injects the caBundle, service reference and
+// selectors again.
+// The Secret is watched by its own informer limited to Namespace and SecretName, so the cache of mgr
+// holds no Secrets and the controller only needs access to Secrets in Namespace. CRDs and APIServices
+// are watched by their own informers too, only when a WebHook names one, so mgr needs no scheme for them.
+func (c *CertWebHook) SetupWithManager(mgr manager.Manager) error {
+	c.setDefaults()
+	if err := c.validateWebHooks(); err != nil {
+		return err
+	}
+	if c.client == nil {
+		if err := c.initClients(mgr.GetConfig()); err != nil {
+			return err
+		}
+	}
+	ctrl, err := controller.New("webhook-cert", mgr, controller.Options{
+		Reconciler: reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			return reconcile.Result{}, c.reconcile()
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	// every event repairs everything, so they share one request
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: c.Namespace, Name: c.SecretName}}}
+	})
+	secrets := c.secretInformerFactory()
+	if err := watchInformer(mgr, ctrl, enqueue, secrets.Start, secrets.Core().V1().Secrets().Informer(), c.isSecret); err != nil {
+		return err
+	}
+	if err := ctrl.Watch(&source.Kind{Type: &admissionregistrationv1.ValidatingWebhookConfiguration{}}, enqueue,
+		predicate.NewPredicateFuncs(c.isValidating)); err != nil {
+		return err
+	}
+	if err := ctrl.Watch(&source.Kind{Type: &admissionregistrationv1.MutatingWebhookConfiguration{}}, enqueue,
+		predicate.NewPredicateFuncs(c.isMutating)); err != nil {
+		return err
+	}
+	if c.hasCRD() {
+		crds := apiextensionsinformers.NewSharedInformerFactory(c.crdClient, 0)
+		informer := crds.Apiextensions().V1().CustomResourceDefinitions().Informer()
+		if err := watchInformer(mgr, ctrl, enqueue, crds.Start, informer, c.isCRD); err != nil {
+			return err
+		}
+	}
+	if c.hasAPIService() {
+		apiServices := dynamicinformer.NewDynamicSharedInformerFactory(c.dynamicClient, 0)
+		informer := apiServices.ForResource(apiServiceResource).Informer()
+		if err := watchInformer(mgr, ctrl, enqueue, apiServices.Start, informer, c.isAPIService); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchInformer has mgr start informer through start, and ctrl handle its events matching match.
+func watchInformer(mgr manager.Manager, ctrl controller.Controller, h handler.EventHandler,
+	start func(stopCh <-chan struct{}), informer cache.SharedIndexInformer, match func(client.Object) bool) error {
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		start(ctx.Done())
+		<-ctx.Done()
+		return nil
+	})); err != nil {
+		return err
+	}
+	return ctrl.Watch(&source.Informer{Informer: informer}, h, predicate.NewPredicateFuncs(match))
+}
+
+func (c *CertWebHook) isSecret(obj client.Object) bool {
+	return obj.GetNamespace() == c.Namespace && obj.GetName() == c.SecretName
+}
+
+func (c *CertWebHook) isValidating(obj client.Object) bool {
+	for _, wk := range c.WebHook {
+		if wk.ValidatingName != "" && wk.ValidatingName == obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CertWebHook) isMutating(obj client.Object) bool {
+	for _, wk := range c.WebHook {
+		if wk.MutatingName != "" && wk.MutatingName == obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CertWebHook) isCRD(obj client.Object) bool {
+	for _, wk := range c.WebHook {
+		if wk.CRDName != "" && wk.CRDName == obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CertWebHook) isAPIService(obj client.Object) bool {
+	for _, wk := range c.WebHook {
+		if wk.APIServiceName != "" && wk.APIServiceName == obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CertWebHook) hasCRD() bool {
+	for _, wk := range c.WebHook {
+		if wk.CRDName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CertWebHook) hasAPIService() bool {
+	for _, wk := range c.WebHook {
+		if wk.APIServiceName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile recreates a missing or incomplete Secret, then repairs the WebHook configurations and the files in CertDir.
+func (c *CertWebHook) reconcile() error {
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err != nil || !c.secretComplete(secret) {
+		certlog.Info("generate certificate", "secret", c.SecretName, "namespace", c.Namespace)
+		secret, err = c.generateSecret()
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.patchWebHook(string(secret.Data[caBundleKey])); err != nil {
+		return err
+	}
+	return c.syncTLSFiles(secret)
+}
+
+// secretComplete tells whether secret holds a parsable certificate with its key and CA.
+func (c *CertWebHook) secretComplete(secret *corev1.Secret) bool {
+	if len(secret.Data[keyKey]) == 0 || len(secret.Data[caBundleKey]) == 0 {
+		return false
+	}
+	if c.SelfSigned && len(secret.Data[caKeyKey]) == 0 {
+		return false
+	}
+	_, err := ParseCertificate(secret.Data[certKey])
+	return err == nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCertWebHook_Reconcile(t *testing.T) {
+	selector := &v1.LabelSelector{MatchLabels: map[string]string{"webhook": "enabled"}}
+	cli := fake.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "validating-cfg"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:         "validating.cuisongliu.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{}},
+		}},
+	})
+	c := &CertWebHook{
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook: []WebHook{{
+			ValidatingName:  "validating-cfg",
+			NamespaceSelect: map[string]*v1.LabelSelector{"validating.cuisongliu.com": selector},
+		}},
+		client: cli,
+	}
+	assertInjected := func(t *testing.T) *corev1.Secret {
+		t.Helper()
+		secret, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		vwebhook, _ := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+		wh := vwebhook.Webhooks[0]
+		if !bytes.Equal(wh.ClientConfig.CABundle, secret.Data[caBundleKey]) {
+			t.Error("caBundle is not the CA of the secret")
+		}
+		if wh.ClientConfig.Service.Name != "service" || wh.ClientConfig.Service.Namespace != "default" {
+			t.Errorf("got service %v", wh.ClientConfig.Service)
+		}
+		if wh.NamespaceSelector == nil || wh.NamespaceSelector.MatchLabels["webhook"] != "enabled" {
+			t.Errorf("got namespaceSelector %v", wh.NamespaceSelector)
+		}
+		crt, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+		if !bytes.Equal(crt, secret.Data[certKey]) {
+			t.Error("tls.crt is not the certificate of the secret")
+		}
+		return secret
+	}
+
+	if err := c.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	first := assertInjected(t)
+
+	t.Run("no drift", func(t *testing.T) {
+		cli.ClearActions()
+		if err := c.reconcile(); err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range cli.Actions() {
+			if a.GetVerb() != "get" {
+				t.Errorf("got action %s %s", a.GetVerb(), a.GetResource().Resource)
+			}
+		}
+	})
+
+	t.Run("manifest applied again", func(t *testing.T) {
+		vwebhook, _ := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+		vwebhook.Webhooks[0].ClientConfig.CABundle = []byte("\n")
+		vwebhook.Webhooks[0].ClientConfig.Service = &admissionregistrationv1.ServiceReference{Name: "placeholder"}
+		vwebhook.Webhooks[0].NamespaceSelector = nil
+		_, _ = cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), vwebhook, v1.UpdateOptions{})
+		if err := c.reconcile(); err != nil {
+			t.Fatal(err)
+		}
+		secret := assertInjected(t)
+		if !bytes.Equal(secret.Data[caBundleKey], first.Data[caBundleKey]) {
+			t.Error("CA was regenerated")
+		}
+	})
+
+	t.Run("secret deleted", func(t *testing.T) {
+		_ = cli.CoreV1().Secrets("default").Delete(context.TODO(), "webhook-cert", v1.DeleteOptions{})
+		if err := c.reconcile(); err != nil {
+			t.Fatal(err)
+		}
+		secret := assertInjected(t)
+		if bytes.Equal(secret.Data[caBundleKey], first.Data[caBundleKey]) {
+			t.Error("CA was not regenerated")
+		}
+	})
+}
+
+func TestCertWebHook_WatchPredicates(t *testing.T) {
+	c := &CertWebHook{
+		Namespace:  "default",
+		SecretName: "webhook-cert",
+		WebHook:    []WebHook{{ValidatingName: "validating-cfg"}, {MutatingName: "mutating-cfg"}},
+	}
+	if c.hasCRD() || c.hasAPIService() {
+		t.Error("CRDs or APIServices are watched without being configured")
+	}
+	c.WebHook = append(c.WebHook, WebHook{CRDName: "crontabs.cuisongliu.com", APIServiceName: "v1.cuisongliu.com"})
+	if !c.hasCRD() || !c.hasAPIService() {
+		t.Error("configured CRDs or APIServices are not watched")
+	}
+	object := func(namespace, name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	if !c.isSecret(object("default", "webhook-cert")) || c.isSecret(object("kube-system", "webhook-cert")) {
+		t.Error("isSecret does not match the secret only")
+	}
+	if !c.isValidating(object("", "validating-cfg")) || c.isValidating(object("", "mutating-cfg")) {
+		t.Error("isValidating does not match the validating configuration only")
+	}
+	if !c.isMutating(object("", "mutating-cfg")) || c.isMutating(object("", "other")) {
+		t.Error("isMutating does not match the mutating configuration only")
+	}
+	if !c.isCRD(object("", "crontabs.cuisongliu.com")) || c.isCRD(object("", "v1.cuisongliu.com")) {
+		t.Error("isCRD does not match the CRD only")
+	}
+	if !c.isAPIService(object("", "v1.cuisongliu.com")) || c.isAPIService(object("", "crontabs.cuisongliu.com")) {
+		t.Error("isAPIService does not match the APIService only")
+	}
+}
+
+func TestCertWebHook_SecretInformer(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+	c := &CertWebHook{Namespace: "default", SecretName: "webhook-cert", client: cli}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory := c.secretInformerFactory()
+	factory.Core().V1().Secrets().Informer()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	for _, a := range cli.Actions() {
+		list, ok := a.(k8stesting.ListAction)
+		if !ok {
+			continue
+		}
+		if list.GetNamespace() != "default" || list.GetListRestrictions().Fields.String() != "metadata.name=webhook-cert" {
+			t.Errorf("secrets are listed in %q with %q", list.GetNamespace(), list.GetListRestrictions().Fields)
+		}
+		return
+	}
+	t.Error("secrets were not listed")
+}
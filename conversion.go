@@ -0,0 +1,183 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"net/http"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConvertFunc converts src into dst.
+type ConvertFunc func(src, dst runtime.Object) error
+
+// VersionConverter converts objects from the version of From to the version of To.
+type VersionConverter struct {
+	From    runtime.Object
+	To      runtime.Object
+	Convert ConvertFunc
+}
+
+// ConversionWebhookFor creates a new Webhook answering ConversionReview requests of CRDs serving several versions.
+// Objects are converted with the VersionConverter of their version pair, or else through the
+// conversion.Hub of their kind when the other versions implement conversion.Convertible.
+func ConversionWebhookFor(converters ...VersionConverter) *ConversionWebhook {
+	return &ConversionWebhook{converters: converters}
+}
+
+// ConversionWebhook is an http.Handler serving the conversion webhook of CRDs.
+type ConversionWebhook struct {
+	converters []VersionConverter
+	scheme     *runtime.Scheme
+}
+
+var _ http.Handler = &ConversionWebhook{}
+
+// InjectScheme injects the scheme knowing the types of all served versions into a ConversionWebhook.
+func (wh *ConversionWebhook) InjectScheme(s *runtime.Scheme) error {
+	wh.scheme = s
+	return nil
+}
+
+// ServeHTTP answers apiextensions.k8s.io/v1 and v1beta1 ConversionReviews in the version of the request.
+func (wh *ConversionWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// v1 and v1beta1 ConversionReviews are the same apart from their apiVersion
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "conversion request is empty", http.StatusBadRequest)
+		return
+	}
+	review.Response = wh.convertReview(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (wh *ConversionWebhook) convertReview(req *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	resp := &apiextensionsv1.ConversionResponse{UID: req.UID}
+	desired, err := schema.ParseGroupVersion(req.DesiredAPIVersion)
+	if err != nil {
+		resp.Result = conversionFailed(err)
+		return resp
+	}
+	for _, raw := range req.Objects {
+		dst, err := wh.convertRaw(raw.Raw, desired)
+		if err != nil {
+			resp.Result = conversionFailed(err)
+			resp.ConvertedObjects = nil
+			return resp
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Object: dst})
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+func conversionFailed(err error) metav1.Status {
+	return metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+}
+
+func (wh *ConversionWebhook) convertRaw(raw []byte, desired schema.GroupVersion) (runtime.Object, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, err
+	}
+	scheme := schemeOrDefault(wh.scheme)
+	srcGVK := typeMeta.GroupVersionKind()
+	src, err := scheme.New(srcGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, src); err != nil {
+		return nil, err
+	}
+	dstGVK := desired.WithKind(srcGVK.Kind)
+	if srcGVK == dstGVK {
+		return src, nil
+	}
+	dst, err := scheme.New(dstGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := wh.convert(src, dst, srcGVK.GroupKind()); err != nil {
+		return nil, fmt.Errorf("convert %s to %s failed: %v", srcGVK, dstGVK, err)
+	}
+	dst.GetObjectKind().SetGroupVersionKind(dstGVK)
+	return dst, nil
+}
+
+func (wh *ConversionWebhook) convert(src, dst runtime.Object, gk schema.GroupKind) error {
+	for _, c := range wh.converters {
+		if sameType(c.From, src) && sameType(c.To, dst) {
+			return c.Convert(src, dst)
+		}
+	}
+	if hub, ok := src.(conversion.Hub); ok {
+		if spoke, ok := dst.(conversion.Convertible); ok {
+			return spoke.ConvertFrom(hub)
+		}
+	}
+	if spoke, ok := src.(conversion.Convertible); ok {
+		if hub, ok := dst.(conversion.Hub); ok {
+			return spoke.ConvertTo(hub)
+		}
+		if dstSpoke, ok := dst.(conversion.Convertible); ok {
+			hub, err := wh.hubFor(gk)
+			if err != nil {
+				return err
+			}
+			if err := spoke.ConvertTo(hub); err != nil {
+				return err
+			}
+			return dstSpoke.ConvertFrom(hub)
+		}
+	}
+	return fmt.Errorf("no conversion from %T to %T", src, dst)
+}
+
+// hubFor returns a new object of the conversion.Hub registered in the scheme for gk.
+func (wh *ConversionWebhook) hubFor(gk schema.GroupKind) (conversion.Hub, error) {
+	scheme := schemeOrDefault(wh.scheme)
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.GroupKind() != gk {
+			continue
+		}
+		obj, err := scheme.New(gvk)
+		if err != nil {
+			return nil, err
+		}
+		if hub, ok := obj.(conversion.Hub); ok {
+			return hub, nil
+		}
+	}
+	return nil, fmt.Errorf("no hub registered for %s", gk)
+}
+
+func sameType(a, b runtime.Object) bool {
+	return a != nil && reflect.TypeOf(a) == reflect.TypeOf(b)
+}
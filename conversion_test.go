@@ -0,0 +1,189 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+var cronGroup = schema.GroupVersion{Group: "batch.cuisongliu.com"}
+
+// cronV1 is a spoke with the schedule in one field.
+type cronV1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Schedule          string `json:"schedule"`
+}
+
+// cronV2 is the hub.
+type cronV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Cron              string `json:"cron"`
+}
+
+// cronV3 is a spoke with the schedule in a nested field.
+type cronV3 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Cron string `json:"cron"`
+	} `json:"spec"`
+}
+
+func (in *cronV1) DeepCopyObject() runtime.Object {
+	out := *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+func (in *cronV2) DeepCopyObject() runtime.Object {
+	out := *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+func (in *cronV3) DeepCopyObject() runtime.Object {
+	out := *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+func (*cronV2) Hub() {}
+
+func (in *cronV1) ConvertTo(dst conversion.Hub) error {
+	dst.(*cronV2).ObjectMeta = in.ObjectMeta
+	dst.(*cronV2).Cron = in.Schedule
+	return nil
+}
+func (in *cronV1) ConvertFrom(src conversion.Hub) error {
+	in.ObjectMeta = src.(*cronV2).ObjectMeta
+	in.Schedule = src.(*cronV2).Cron
+	return nil
+}
+func (in *cronV3) ConvertTo(dst conversion.Hub) error {
+	dst.(*cronV2).ObjectMeta = in.ObjectMeta
+	dst.(*cronV2).Cron = in.Spec.Cron
+	return nil
+}
+func (in *cronV3) ConvertFrom(src conversion.Hub) error {
+	in.ObjectMeta = src.(*cronV2).ObjectMeta
+	in.Spec.Cron = src.(*cronV2).Cron
+	return nil
+}
+
+func newCronScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	s.AddKnownTypeWithName(schema.GroupVersionKind{Group: cronGroup.Group, Version: "v1", Kind: "Cron"}, &cronV1{})
+	s.AddKnownTypeWithName(schema.GroupVersionKind{Group: cronGroup.Group, Version: "v2", Kind: "Cron"}, &cronV2{})
+	s.AddKnownTypeWithName(schema.GroupVersionKind{Group: cronGroup.Group, Version: "v3", Kind: "Cron"}, &cronV3{})
+	return s
+}
+
+func TestConversionWebhook(t *testing.T) {
+	explicit := VersionConverter{From: &cronV3{}, To: &cronV1{}, Convert: func(src, dst runtime.Object) error {
+		dst.(*cronV1).Name = src.(*cronV3).Name
+		dst.(*cronV1).Schedule = "explicit " + src.(*cronV3).Spec.Cron
+		return nil
+	}}
+	tests := []struct {
+		name          string
+		reviewVersion string
+		object        string
+		desired       string
+		want          string
+		wantFailure   bool
+	}{
+		{name: "spoke to hub", reviewVersion: "apiextensions.k8s.io/v1", desired: "v2",
+			object: `{"apiVersion":"batch.cuisongliu.com/v1","kind":"Cron","metadata":{"name":"a"},"schedule":"* * * * *"}`,
+			want:   `{"kind":"Cron","apiVersion":"batch.cuisongliu.com/v2","metadata":{"name":"a","creationTimestamp":null},"cron":"* * * * *"}`},
+		{name: "hub to spoke", reviewVersion: "apiextensions.k8s.io/v1beta1", desired: "v1",
+			object: `{"apiVersion":"batch.cuisongliu.com/v2","kind":"Cron","metadata":{"name":"a"},"cron":"* * * * *"}`,
+			want:   `{"kind":"Cron","apiVersion":"batch.cuisongliu.com/v1","metadata":{"name":"a","creationTimestamp":null},"schedule":"* * * * *"}`},
+		{name: "spoke to spoke", reviewVersion: "apiextensions.k8s.io/v1", desired: "v3",
+			object: `{"apiVersion":"batch.cuisongliu.com/v1","kind":"Cron","metadata":{"name":"a"},"schedule":"* * * * *"}`,
+			want:   `{"kind":"Cron","apiVersion":"batch.cuisongliu.com/v3","metadata":{"name":"a","creationTimestamp":null},"spec":{"cron":"* * * * *"}}`},
+		{name: "explicit", reviewVersion: "apiextensions.k8s.io/v1", desired: "v1",
+			object: `{"apiVersion":"batch.cuisongliu.com/v3","kind":"Cron","metadata":{"name":"a"},"spec":{"cron":"* * * * *"}}`,
+			want:   `{"kind":"Cron","apiVersion":"batch.cuisongliu.com/v1","metadata":{"name":"a","creationTimestamp":null},"schedule":"explicit * * * * *"}`},
+		{name: "unknown version", reviewVersion: "apiextensions.k8s.io/v1", desired: "v4",
+			object:      `{"apiVersion":"batch.cuisongliu.com/v1","kind":"Cron","metadata":{"name":"a"},"schedule":"* * * * *"}`,
+			wantFailure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := ConversionWebhookFor(explicit)
+			_ = wh.InjectScheme(newCronScheme())
+			body, _ := json.Marshal(map[string]interface{}{
+				"apiVersion": tt.reviewVersion,
+				"kind":       "ConversionReview",
+				"request": map[string]interface{}{
+					"uid":               "uid",
+					"desiredAPIVersion": cronGroup.Group + "/" + tt.desired,
+					"objects":           []json.RawMessage{json.RawMessage(tt.object)},
+				},
+			})
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+
+			review := apiextensionsv1.ConversionReview{}
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatal(err)
+			}
+			if review.APIVersion != tt.reviewVersion || review.Response == nil || review.Response.UID != "uid" {
+				t.Fatalf("got review %s", w.Body.String())
+			}
+			if tt.wantFailure {
+				if review.Response.Result.Status != metav1.StatusFailure {
+					t.Errorf("got result %v, want failure", review.Response.Result)
+				}
+				return
+			}
+			if review.Response.Result.Status != metav1.StatusSuccess || len(review.Response.ConvertedObjects) != 1 {
+				t.Fatalf("got response %s", w.Body.String())
+			}
+			got := review.Response.ConvertedObjects[0].Raw
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookObject_ConversionPaths(t *testing.T) {
+	server := &webhook.Server{}
+	crons := &WebhookObject{WK: server, Obj: &cronV2{}, Scheme: newCronScheme(), Conversion: ConversionWebhookFor()}
+	if err := crons.Init(); err != nil {
+		t.Fatal(err)
+	}
+	configMaps := &WebhookObject{WK: server, Obj: &corev1.ConfigMap{}, Conversion: ConversionWebhookFor()}
+	if err := configMaps.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if crons.ConversionPath != "/convert-batch-cuisongliu-com-v2-cron" || configMaps.ConversionPath != "/convert--v1-configmap" {
+		t.Errorf("got paths %s and %s", crons.ConversionPath, configMaps.ConversionPath)
+	}
+}
@@ -18,7 +18,7 @@ package webhook
 import (
 	"context"
 	"encoding/json"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -30,15 +30,30 @@ type Defaulter interface {
 }
 
 // DefaultingWebhookFor creates a new Webhook for Defaulting the provided type.
+// Every request works on a shallow copy of defaulter.
 func DefaultingWebhookFor(defaulter Defaulter) *admission.Webhook {
+	return DefaultingWebhookForFunc(prototypeFunc(defaulter))
+}
+
+// DefaultingWebhookForFunc creates a new Webhook for Defaulting, calling newFunc
+// for a fresh Defaulter on every request.
+// Requests are decoded into the type returned by OutRuntimeObject of the Defaulter.
+func DefaultingWebhookForFunc(newFunc RuntimeObjectFunc) *admission.Webhook {
 	return &admission.Webhook{
-		Handler: &mutatingHandler{defaulter: defaulter},
+		Handler: &mutatingHandler{
+			newDefaulter: func() Defaulter {
+				return newFunc().(Defaulter)
+			},
+			object: newFunc().OutRuntimeObject(),
+		},
 	}
 }
 
 type mutatingHandler struct {
-	defaulter Defaulter
-	decoder   *admission.Decoder
+	newDefaulter func() Defaulter
+	object       runtime.Object
+	decoder      *admission.Decoder
+	scheme       *runtime.Scheme
 }
 
 var _ admission.DecoderInjector = &mutatingHandler{}
@@ -49,23 +64,97 @@ func (h *mutatingHandler) InjectDecoder(d *admission.Decoder) error {
 	return nil
 }
 
+// InjectScheme injects the scheme used to find the type of untyped requests into a mutatingHandler.
+func (h *mutatingHandler) InjectScheme(s *runtime.Scheme) error {
+	h.scheme = s
+	return nil
+}
+
 // Handle handles admission requests.
 func (h *mutatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
-	if h.defaulter == nil {
+	if h.newDefaulter == nil {
 		panic("callback should never be nil")
 	}
+	defaulter := h.newDefaulter()
+	return withWarnings(h.handle(req, defaulter), warningsOf(defaulter))
+}
 
+func (h *mutatingHandler) handle(req admission.Request, defaulter Defaulter) admission.Response {
 	// Get the object in the request
-	//obj := h.callback(h.defaulter.OutRuntimeObject().DeepCopyObject(), h.defaulter.GetClient())
-	into := &unstructured.Unstructured{}
+	into := newRequestObject(h.scheme, h.object, req)
 	err := h.decoder.Decode(req, into)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
-	h.defaulter.IntoRuntimeObject(into)
+	defaulter.IntoRuntimeObject(into)
 	// Default the object
-	h.defaulter.Default()
-	marshalled, err := json.Marshal(h.defaulter.OutRuntimeObject())
+	defaulter.Default()
+	marshalled, err := json.Marshal(defaulter.OutRuntimeObject())
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// Create the patch
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// CustomDefaulter defines functions for setting defaults on resources.
+// Unlike Defaulter it is given the object and a context carrying the admission request,
+// see RequestFromContext.
+type CustomDefaulter interface {
+	Default(ctx context.Context, obj runtime.Object) error
+}
+
+// CustomDefaultingWebhookFor creates a new Webhook for Defaulting objects of the type of obj.
+func CustomDefaultingWebhookFor(obj runtime.Object, defaulter CustomDefaulter) *admission.Webhook {
+	return &admission.Webhook{
+		Handler: &customMutatingHandler{object: obj, defaulter: defaulter},
+	}
+}
+
+type customMutatingHandler struct {
+	object    runtime.Object
+	defaulter CustomDefaulter
+	decoder   *admission.Decoder
+	scheme    *runtime.Scheme
+}
+
+var _ admission.DecoderInjector = &customMutatingHandler{}
+
+// InjectDecoder injects the decoder into a customMutatingHandler.
+func (h *customMutatingHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+// InjectScheme injects the scheme used to find the type of untyped requests into a customMutatingHandler.
+func (h *customMutatingHandler) InjectScheme(s *runtime.Scheme) error {
+	h.scheme = s
+	return nil
+}
+
+// Handle handles admission requests.
+func (h *customMutatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if h.defaulter == nil {
+		panic("defaulter should never be nil")
+	}
+	ctx, warnings := newContextWithWarnings(NewContextWithRequest(ctx, req))
+	return withWarnings(h.handle(ctx, req), warnings.get())
+}
+
+func (h *customMutatingHandler) handle(ctx context.Context, req admission.Request) admission.Response {
+	// Get the object in the request
+	obj := newRequestObject(h.scheme, h.object, req)
+	err := h.decoder.Decode(req, obj)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// Default the object
+	err = h.defaulter.Default(ctx, obj)
+	if err != nil {
+		return deniedFromError(req, err)
+	}
+	marshalled, err := json.Marshal(obj)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
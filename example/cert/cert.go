@@ -18,6 +18,7 @@ package main
 import (
 	"fmt"
 	v1 "github.com/cuisongliu/webhook"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"os"
 )
@@ -26,17 +27,37 @@ func main() {
 	certDir := os.TempDir() + "/webhook/serving-certs"
 	obj := make(map[string]*metav1.LabelSelector)
 	namespace := make(map[string]*metav1.LabelSelector)
+	rules := []admissionregistrationv1.RuleWithOperations{{
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+	}}
 	fmt.Printf("certDir: %s\n", certDir)
 	w := &v1.CertWebHook{
 		Subject:     nil,       //证书数据
 		CertDir:     certDir,   //生成的证书位置
+		SelfSigned:  false,     //是否使用自签CA代替CSR API
 		Namespace:   "default", //秘钥以及webhook的svc的namespace
 		ServiceName: "svcName", //生成webhook的对应service名称
 		SecretName:  "certs",   //存放证书名称
 		CsrName:     "csr",     //csr证书资源名称
+
+		//v1的CSR必须指定签发证书的signer，以及该signer的CA（注入caBundle）
+		SignerName:     "cuisongliu.com/webhook-serving",
+		SignerCABundle: os.Getenv("SIGNER_CA_BUNDLE"),
 		WebHook: []v1.WebHook{
-			{MutatingName: "mutating-cfg", ObjectSelect: obj, NamespaceSelect: namespace},
-			{ValidatingName: "validating-cfg", ObjectSelect: obj, NamespaceSelect: namespace},
+			{
+				MutatingName: "mutating-cfg", ObjectSelect: obj, NamespaceSelect: namespace,
+				//不存在时自动创建
+				Mutating: []v1.WebhookDefinition{
+					{Name: "mpod.time.kb.io", Path: "/mutate-time-core-v1-pod", Rules: rules, FailurePolicy: admissionregistrationv1.Ignore},
+				},
+			},
+			{
+				ValidatingName: "validating-cfg", ObjectSelect: obj, NamespaceSelect: namespace,
+				Validating: []v1.WebhookDefinition{
+					{Name: "vpod.time.kb.io", Path: "/validate-time-core-v1-pod", Rules: rules, FailurePolicy: admissionregistrationv1.Ignore},
+				},
+			},
 		},
 	}
 	err := w.Init()
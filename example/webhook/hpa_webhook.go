@@ -38,8 +38,14 @@ func (a *HPAWebhook) GetClient() client.Client {
 	return a.client
 }
 func (r *HPAWebhook) IntoRuntimeObject(object runtime.Object) {
+	if obj, ok := object.(*hpav1.HorizontalPodAutoscaler); ok {
+		r.object = obj
+		return
+	}
 	obj := &hpav1.HorizontalPodAutoscaler{}
-	_ = v1.JsonConvert(object, obj)
+	if err := v1.JsonConvert(object, obj); err != nil {
+		hpalog.Error(err, "convert object failed")
+	}
 	r.object = obj
 }
 
@@ -50,7 +56,7 @@ func (a *HPAWebhook) InjectClient(c client.Client) error {
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 
-// +kubebuilder:webhook:path=/mutate-autoscaling-v2beta1-hpa,mutating=true,failurePolicy=fail,groups=autoscaling,resources=horizontalpodautoscalers,verbs=create;update,versions=v2beta1,name=mhorizontalpodautoscaler.kb.io
+// The webhook configurations are generated from the WebhookObject in setup.go by webhook.WriteManifests.
 
 var _ v1.Defaulter = &HPAWebhook{}
 
@@ -60,8 +66,7 @@ func (r *HPAWebhook) Default() {
 	// TODO(user): fill in your defaulting logic.
 }
 
-// TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
-// +kubebuilder:webhook:verbs=create;update,path=/validate-autoscaling-v2beta1-hpa,mutating=false,failurePolicy=fail,groups=autoscaling,resources=horizontalpodautoscalers,versions=v2beta1,name=vhorizontalpodautoscaler.kb.io
+// TODO(user): add DELETE to Operations in setup.go if you want to enable deletion validation.
 
 var _ v1.Validator = &HPAWebhook{}
 
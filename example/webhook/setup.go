@@ -22,15 +22,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
-func SetupWebhook(wk *webhook.Server, mgr ctrl.Manager) {
+func SetupWebhook(wk *webhook.Server, mgr ctrl.Manager) error {
 	c := mgr.GetClient()
+	// the paths are /validate-autoscaling-v2beta1-horizontalpodautoscaler and /mutate-autoscaling-v2beta1-horizontalpodautoscaler
 	wkhpa := &v1.WebhookObject{
-		WK:             wk,
-		Webhook:        &HPAWebhook{},
-		Obj:            &hpav1.HorizontalPodAutoscaler{},
-		ValidatingPath: "/validate-autoscaling-v2beta1-hpa",
-		DefaultingPath: "/mutate-autoscaling-v2beta1-hpa",
-		Client:         c,
+		WK:      wk,
+		Webhook: &HPAWebhook{},
+		Obj:     &hpav1.HorizontalPodAutoscaler{},
+		Client:  c,
 	}
-	wkhpa.Init()
+	return wkhpa.Init()
 }
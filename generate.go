@@ -18,7 +18,9 @@ package webhook
 import (
 	"flag"
 	"github.com/pkg/errors"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -28,8 +30,17 @@ import (
 )
 
 type WebHook struct {
-	ValidatingName  string
-	MutatingName    string
+	ValidatingName string
+	MutatingName   string
+	// Validating describes the webhooks of ValidatingName, which is then created or updated
+	// with server-side apply instead of patched, so it need not exist beforehand.
+	Validating []WebhookDefinition
+	// Mutating describes the webhooks of MutatingName like Validating.
+	Mutating []WebhookDefinition
+	// CRDName is the CustomResourceDefinition whose conversion webhook gets the caBundle and the service.
+	CRDName string
+	// APIServiceName is the APIService of an aggregated API server which gets the caBundle and the service.
+	APIServiceName  string
 	ObjectSelect    map[string]*v1.LabelSelector
 	NamespaceSelect map[string]*v1.LabelSelector
 }
@@ -38,22 +49,50 @@ type CertWebHook struct {
 	//证书相关
 	Subject []string
 	CertDir string
+	// SelfSigned signs the serving certificate with a CA generated and kept in the Secret
+	// instead of the certificates API, the CA is injected as caBundle.
+	SelfSigned bool
+	// KeyAlgorithm is the algorithm of the generated keys, RSA2048 by default.
+	KeyAlgorithm KeyAlgorithm
+	// RotateFraction is the fraction of the certificate lifetime after which Rotate renews it, 0.8 by default.
+	RotateFraction float64
 	//kubernetes相关资源
+	// Kubeconfig is the kubeconfig used instead of the in-cluster config, ~/.kube/config outside a cluster by default.
+	Kubeconfig  string
 	Namespace   string
 	ServiceName string
 	SecretName  string
 	CsrName     string
-	WebHook     []WebHook
+	// SignerName is the signerName of the CSR, required on certificates.k8s.io/v1 where it must be
+	// a signer issuing serving certificates, e.g. a custom one. v1beta1 uses the legacy signer when it is empty.
+	SignerName string
+	// SignerCABundle is the PEM encoded CA of the signer of SignerName, injected as the caBundle so the
+	// apiserver trusts the signed certificate. It is required by signers other than the legacy and
+	// kubelet-serving ones, which fall back to the client-ca-file of the cluster.
+	SignerCABundle string
+	// ExpirationSeconds is the requested lifetime of the signed certificate, the signer decides when it is 0.
+	ExpirationSeconds int32
+	WebHook           []WebHook
+	// LeaderElection makes Rotate renew certificates only in the replica holding the lease LeaseName,
+	// Bootstrap always takes it.
+	LeaderElection bool
+	// LeaseName is the Lease in Namespace locking the certificates, the SecretName with a -lock suffix by default.
+	LeaseName string
+	// Identity is the holder identity of this replica in the Lease, the hostname with a random suffix by default.
+	Identity string
 
-	client *kubernetes.Clientset
+	client    kubernetes.Interface
+	crdClient apiextensionsclient.Interface
+	// dynamicClient reaches APIServices.
+	dynamicClient dynamic.Interface
+	serving       servingCertificate
 }
 
-func newK8sClient() (*kubernetes.Clientset, error) {
-	var (
-		config *rest.Config
-		err    error
-	)
-	config, err = rest.InClusterConfig()
+func newK8sConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	config, err := rest.InClusterConfig()
 	if err != nil {
 		var kubeconfig = filepath.Join(homedir.HomeDir(), ".kube", "config")
 		flag.Parse()
@@ -62,6 +101,14 @@ func newK8sClient() (*kubernetes.Clientset, error) {
 			return nil, err
 		}
 	}
+	return config, nil
+}
+
+func newK8sClient() (*kubernetes.Clientset, error) {
+	config, err := newK8sConfig("")
+	if err != nil {
+		return nil, err
+	}
 	// creates the clientSet
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -70,7 +117,17 @@ func newK8sClient() (*kubernetes.Clientset, error) {
 	return client, nil
 }
 
+// Init sets the defaults and creates the clients. The WebHook are checked by the methods patching them.
 func (c *CertWebHook) Init() error {
+	c.setDefaults()
+	config, err := newK8sConfig(c.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	return c.initClients(config)
+}
+
+func (c *CertWebHook) setDefaults() {
 	if c.Subject == nil || len(c.Subject) == 0 {
 		c.Subject = []string{"cuisongliu CN"}
 	}
@@ -89,20 +146,45 @@ func (c *CertWebHook) Init() error {
 	if c.CsrName == "" {
 		c.CsrName = "webhook-csr"
 	}
+}
+
+// validateWebHooks fails when no WebHook is configured or one describes webhooks without a configuration name.
+func (c *CertWebHook) validateWebHooks() error {
 	if c.WebHook == nil || len(c.WebHook) == 0 {
 		return errors.New("webhook未配置，请配置后重新操作。")
 	}
-	var err error
+	for _, wk := range c.WebHook {
+		if len(wk.Validating) != 0 && wk.ValidatingName == "" {
+			return errors.New("validating webhooks are described without a ValidatingName")
+		}
+		if len(wk.Mutating) != 0 && wk.MutatingName == "" {
+			return errors.New("mutating webhooks are described without a MutatingName")
+		}
+	}
+	return nil
+}
 
-	c.client, err = newK8sClient()
+func (c *CertWebHook) initClients(config *rest.Config) error {
+	var err error
+	c.client, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	c.crdClient, err = apiextensionsclient.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	c.dynamicClient, err = dynamic.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 	return nil
-
 }
 
 func (c *CertWebHook) Generator() error {
+	if err := c.validateWebHooks(); err != nil {
+		return err
+	}
 	secret, err := c.generateSecret()
 	if err != nil {
 		return err
@@ -16,8 +16,22 @@ limitations under the License.
 package webhook
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"path"
+	"reflect"
 	"testing"
 )
 
@@ -57,8 +71,8 @@ func TestCertWebHook_Generator(t *testing.T) {
 		}, wantErr: false},
 	}
 	t.Log("before delete secrets and csr")
-	_ = cli.CoreV1().Secrets("default").Delete("webhook-cert", &v1.DeleteOptions{})
-	_ = cli.CertificatesV1beta1().CertificateSigningRequests().Delete("webhook-csr", &v1.DeleteOptions{})
+	_ = cli.CoreV1().Secrets("default").Delete(context.TODO(), "webhook-cert", v1.DeleteOptions{})
+	_ = cli.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), "webhook-csr", v1.DeleteOptions{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -79,6 +93,222 @@ func TestCertWebHook_Generator(t *testing.T) {
 		})
 	}
 	t.Log("after delete secrets and csr")
-	_ = cli.CoreV1().Secrets("default").Delete("webhook-cert", &v1.DeleteOptions{})
-	_ = cli.CertificatesV1beta1().CertificateSigningRequests().Delete("webhook-csr", &v1.DeleteOptions{})
+	_ = cli.CoreV1().Secrets("default").Delete(context.TODO(), "webhook-cert", v1.DeleteOptions{})
+	_ = cli.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), "webhook-csr", v1.DeleteOptions{})
+}
+
+func TestCertWebHook_GeneratorSelfSigned(t *testing.T) {
+	cli := fake.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "validating-cfg"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:         "validating.cuisongliu.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{}},
+		}},
+	})
+	c := &CertWebHook{
+		Subject:     []string{"www.cuisongliu.com"},
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook:     []WebHook{{ValidatingName: "validating-cfg"}},
+		client:      cli,
+	}
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{caBundleKey, caKeyKey, certKey, keyKey} {
+		if len(secret.Data[k]) == 0 {
+			t.Errorf("secret data %s is empty", k)
+		}
+	}
+	vwebhook, err := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(vwebhook.Webhooks[0].ClientConfig.CABundle, secret.Data[caBundleKey]) {
+		t.Error("caBundle is not the self-signed CA")
+	}
+	crt, err := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(crt, secret.Data[certKey]) {
+		t.Error("tls.crt is not the certificate of the secret")
+	}
+
+	// a second run reuses the CA of the secret
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if !bytes.Equal(again.Data[caBundleKey], secret.Data[caBundleKey]) {
+		t.Error("CA was regenerated")
+	}
+}
+
+// signOnApproval makes cli sign CSRs once they are approved.
+func signOnApproval(cli *fake.Clientset) {
+	cli.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "approval" {
+			return false, nil, nil
+		}
+		switch csr := action.(k8stesting.UpdateAction).GetObject().(type) {
+		case *certificatesv1.CertificateSigningRequest:
+			csr.Status.Certificate = []byte("v1 certificate")
+		case *certificatesv1beta1.CertificateSigningRequest:
+			csr.Status.Certificate = []byte("v1beta1 certificate")
+		}
+		return false, nil, nil
+	})
+}
+
+func TestCertWebHook_PathCsr(t *testing.T) {
+	tests := []struct {
+		name         string
+		groupVersion string
+		signerName   string
+		wantErr      bool
+	}{
+		{name: "v1 without signer", groupVersion: "certificates.k8s.io/v1", wantErr: true},
+		{name: "v1 custom signer", groupVersion: "certificates.k8s.io/v1", signerName: "cuisongliu.com/webhook"},
+		{name: "v1beta1", groupVersion: "certificates.k8s.io/v1beta1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := fake.NewSimpleClientset()
+			cli.Resources = []*v1.APIResourceList{{GroupVersion: tt.groupVersion}}
+			signOnApproval(cli)
+			c := &CertWebHook{
+				Subject:           []string{"cuisongliu"},
+				Namespace:         "default",
+				ServiceName:       "service",
+				CsrName:           "webhook-csr",
+				SignerName:        tt.signerName,
+				ExpirationSeconds: 3600,
+				client:            cli,
+			}
+			csr, key, err := c.generateTLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret := &corev1.Secret{Data: map[string][]byte{csrKey: csr, keyKey: key}}
+			err = c.pathCsr(secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pathCsr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := cli.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "webhook-csr", v1.GetOptions{}); err == nil {
+					t.Error("csr without signerName was created")
+				}
+				return
+			}
+			if tt.groupVersion == "certificates.k8s.io/v1beta1" {
+				if string(secret.Data[certKey]) != "v1beta1 certificate" {
+					t.Errorf("got certificate %q", secret.Data[certKey])
+				}
+				got, err := cli.CertificatesV1beta1().CertificateSigningRequests().Get(context.TODO(), "webhook-csr", v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Spec.SignerName != nil {
+					t.Errorf("got signerName %s, want the legacy signer", *got.Spec.SignerName)
+				}
+				block, _ := pem.Decode(got.Spec.Request)
+				if block == nil {
+					t.Fatal("csr request is not PEM")
+				}
+				req, err := x509.ParseCertificateRequest(block.Bytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if req.Subject.CommonName != "service.default" || !reflect.DeepEqual(req.Subject.Organization, []string{"cuisongliu"}) {
+					t.Errorf("got subject %s", req.Subject)
+				}
+				return
+			}
+			if string(secret.Data[certKey]) != "v1 certificate" {
+				t.Errorf("got certificate %q", secret.Data[certKey])
+			}
+			got, err := cli.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "webhook-csr", v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.SignerName != tt.signerName {
+				t.Errorf("got signerName %s, want %s", got.Spec.SignerName, tt.signerName)
+			}
+			if got.Spec.ExpirationSeconds == nil || *got.Spec.ExpirationSeconds != 3600 {
+				t.Errorf("got expirationSeconds %v", got.Spec.ExpirationSeconds)
+			}
+		})
+	}
+}
+
+func TestCertWebHook_SignerCABundle(t *testing.T) {
+	tests := []struct {
+		name           string
+		groupVersion   string
+		signerName     string
+		signerCABundle string
+		wantCABundle   string
+		wantErr        bool
+	}{
+		{name: "custom signer", groupVersion: "certificates.k8s.io/v1", signerName: "cuisongliu.com/webhook", signerCABundle: "signer ca", wantCABundle: "signer ca"},
+		{name: "custom signer without its CA", groupVersion: "certificates.k8s.io/v1", signerName: "cuisongliu.com/webhook", wantErr: true},
+		{name: "kubelet-serving", groupVersion: "certificates.k8s.io/v1", signerName: "kubernetes.io/kubelet-serving", wantCABundle: "cluster ca"},
+		{name: "legacy", groupVersion: "certificates.k8s.io/v1beta1", wantCABundle: "cluster ca"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := newPlanClient()
+			cli.Resources = []*v1.APIResourceList{{GroupVersion: tt.groupVersion}}
+			signOnApproval(cli)
+			c := &CertWebHook{
+				Namespace:      "default",
+				ServiceName:    "placeholder",
+				SecretName:     "webhook-cert",
+				CsrName:        "webhook-csr",
+				SignerName:     tt.signerName,
+				SignerCABundle: tt.signerCABundle,
+				WebHook:        []WebHook{{ValidatingName: "validating-cfg"}},
+				client:         cli,
+			}
+			csr, key, err := c.generateTLS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err := cli.CoreV1().Secrets("default").Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "webhook-cert"},
+				Data:       map[string][]byte{csrKey: csr, keyKey: key},
+			}, v1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err = c.signSecret(secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("signSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := cli.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "webhook-csr", v1.GetOptions{}); err == nil {
+					t.Error("csr was created without the CA of its signer")
+				}
+				return
+			}
+			if err := c.patchWebHook(string(secret.Data[caBundleKey])); err != nil {
+				t.Fatal(err)
+			}
+			vwebhook, err := cli.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "validating-cfg", v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(vwebhook.Webhooks[0].ClientConfig.CABundle); got != tt.wantCABundle {
+				t.Errorf("got caBundle %q, want %q", got, tt.wantCABundle)
+			}
+		})
+	}
 }
@@ -1,11 +1,14 @@
 module github.com/cuisongliu/webhook
 
-go 1.13
+go 1.16
 
 require (
 	github.com/pkg/errors v0.9.1
-	k8s.io/api v0.17.2
-	k8s.io/apimachinery v0.17.2
-	k8s.io/client-go v0.17.2
-	sigs.k8s.io/controller-runtime v0.5.0
+	gomodules.xyz/jsonpatch/v2 v2.2.0
+	k8s.io/api v0.22.2
+	k8s.io/apiextensions-apiserver v0.22.2
+	k8s.io/apimachinery v0.22.2
+	k8s.io/client-go v0.22.2
+	sigs.k8s.io/controller-runtime v0.10.3
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"time"
+)
+
+// CertInfo describes the certificate stored in the Secret.
+type CertInfo struct {
+	Namespace string    `json:"namespace"`
+	Secret    string    `json:"secret"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	RenewAt   time.Time `json:"renewAt"`
+	CABundle  []CAInfo  `json:"caBundle"`
+}
+
+// CAInfo describes a CA of the caBundle.
+type CAInfo struct {
+	Subject     string    `json:"subject"`
+	NotAfter    time.Time `json:"notAfter"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// Inspect describes the certificate and the CAs stored in the Secret.
+func (c *CertWebHook) Inspect() (*CertInfo, error) {
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseCertificate(secret.Data[certKey])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate of secret [%s] failed %s", c.SecretName, err)
+	}
+	renewAt, err := c.renewAt(secret)
+	if err != nil {
+		return nil, err
+	}
+	info := &CertInfo{
+		Namespace: c.Namespace,
+		Secret:    c.SecretName,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		RenewAt:   renewAt,
+	}
+	bundle := secret.Data[caBundleKey]
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		info.CABundle = append(info.CABundle, CAInfo{
+			Subject:     ca.Subject.String(),
+			NotAfter:    ca.NotAfter,
+			Fingerprint: fingerprint(pem.EncodeToMemory(block)),
+		})
+	}
+	return info, nil
+}
+
+// Write writes the description to w as PlanText for people or as PlanJSON for pipelines.
+func (i *CertInfo) Write(w io.Writer, format string) error {
+	switch format {
+	case PlanJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(i)
+	case PlanText, "":
+		_, err := io.WriteString(w, i.String())
+		return err
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// String returns the description as PlanText.
+func (i *CertInfo) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Secret:     %s/%s\n", i.Namespace, i.Secret)
+	fmt.Fprintf(b, "Subject:    %s\n", i.Subject)
+	fmt.Fprintf(b, "Issuer:     %s\n", i.Issuer)
+	fmt.Fprintf(b, "DNS names:  %s\n", strings.Join(i.DNSNames, ", "))
+	fmt.Fprintf(b, "Not before: %s\n", i.NotBefore.Format(time.RFC3339))
+	fmt.Fprintf(b, "Not after:  %s\n", i.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(b, "Renew at:   %s\n", i.RenewAt.Format(time.RFC3339))
+	b.WriteString("CA bundle:\n")
+	for _, ca := range i.CABundle {
+		fmt.Fprintf(b, "  %s %s, not after %s\n", ca.Fingerprint, ca.Subject, ca.NotAfter.Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCertWebHook_Inspect(t *testing.T) {
+	c := &CertWebHook{SelfSigned: true, Namespace: "default", ServiceName: "service", SecretName: "webhook-cert"}
+	caCert, caKey, cert, key, err := c.generateSelfSignedTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.client = fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "webhook-cert"},
+		Data:       map[string][]byte{caBundleKey: caCert, caKeyKey: caKey, certKey: cert, keyKey: key},
+	})
+	info, err := c.Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.DNSNames) != 3 || info.DNSNames[0] != "service.default" {
+		t.Errorf("got dns names %v", info.DNSNames)
+	}
+	if lifetime := info.NotAfter.Sub(info.NotBefore); lifetime != certValidity {
+		t.Errorf("got lifetime %s", lifetime)
+	}
+	if !info.RenewAt.After(info.NotBefore) || !info.RenewAt.Before(info.NotAfter) {
+		t.Errorf("got renewal at %s", info.RenewAt.Format(time.RFC3339))
+	}
+	if len(info.CABundle) != 1 || info.CABundle[0].Fingerprint != fingerprint(caCert) || info.CABundle[0].Subject != info.Issuer {
+		t.Errorf("got ca bundle %+v", info.CABundle)
+	}
+	if !strings.Contains(info.String(), "Secret:     default/webhook-cert\n") {
+		t.Errorf("got text\n%s", info)
+	}
+}
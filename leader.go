@@ -0,0 +1,149 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	leaseDuration      = 15 * time.Second
+	leaseRenewDeadline = 10 * time.Second
+	leaseRetryPeriod   = 2 * time.Second
+	// secretPollInterval is the time between two reads of the Secret by replicas not holding the lease.
+	secretPollInterval = time.Second
+)
+
+// Bootstrap runs Generator in the replica holding the lease LeaseName, so several replicas starting together
+// do not race on the Secret and the CSR. The other replicas wait for a valid Secret and write CertDir from it.
+// It returns once CertDir is written, or when ctx is done.
+func (c *CertWebHook) Bootstrap(ctx context.Context) error {
+	return c.runLeading(ctx, c.Generator, c.followSecret)
+}
+
+// runLeading runs lead once this replica holds the lease, or follow until it does. It returns the result of
+// whichever finishes first, except that a running lead is always waited for, then releases the lease.
+func (c *CertWebHook) runLeading(ctx context.Context, lead func() error, follow func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// started tells under mu whether lead was called, stopped keeps it from being called once runLeading returns
+	var mu sync.Mutex
+	started, stopped := false, false
+	leadDone := make(chan error, 1)
+	followDone := make(chan error, 1)
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            c.leaseLock(),
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            c.leaseName(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				mu.Lock()
+				if stopped || ctx.Err() != nil {
+					mu.Unlock()
+					return
+				}
+				started = true
+				mu.Unlock()
+				leadDone <- lead()
+			},
+			OnStoppedLeading: func() {},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		le.Run(ctx)
+	}()
+	go func() {
+		followDone <- follow(ctx)
+	}()
+
+	leadFinished := false
+	select {
+	case err = <-leadDone:
+		leadFinished = true
+	case err = <-followDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	mu.Lock()
+	stopped = true
+	leadRunning := started && !leadFinished
+	mu.Unlock()
+	if leadRunning {
+		err = <-leadDone
+	}
+	cancel()
+	<-runDone
+	return err
+}
+
+// followSecret waits until the Secret holds a certificate not due for renewal and writes it into CertDir.
+func (c *CertWebHook) followSecret(ctx context.Context) error {
+	ticker := time.NewTicker(secretPollInterval)
+	defer ticker.Stop()
+	for {
+		secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(ctx, c.SecretName, v1.GetOptions{})
+		if err == nil && c.secretComplete(secret) && !c.needsRenewal(secret, time.Now()) {
+			return c.syncTLSFiles(secret)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *CertWebHook) leaseLock() *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Namespace: c.Namespace,
+			Name:      c.leaseName(),
+		},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.identity()},
+	}
+}
+
+func (c *CertWebHook) leaseName() string {
+	if c.LeaseName == "" {
+		return fmt.Sprintf("%s-lock", c.SecretName)
+	}
+	return c.LeaseName
+}
+
+func (c *CertWebHook) identity() string {
+	if c.Identity == "" {
+		hostname, _ := os.Hostname()
+		c.Identity = fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+	}
+	return c.Identity
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCertWebHook_Bootstrap(t *testing.T) {
+	cli := fake.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "validating-cfg"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:         "validating.cuisongliu.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{}},
+		}},
+	})
+	var creates int32
+	cli.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&creates, 1)
+		return false, nil, nil
+	})
+	replicas := make([]*CertWebHook, 3)
+	for i := range replicas {
+		replicas[i] = &CertWebHook{
+			CertDir:     t.TempDir(),
+			SelfSigned:  true,
+			Namespace:   "default",
+			ServiceName: "service",
+			SecretName:  "webhook-cert",
+			WebHook:     []WebHook{{ValidatingName: "validating-cfg"}},
+			Identity:    fmt.Sprintf("replica-%d", i),
+			client:      cli,
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, r := range replicas {
+		wg.Add(1)
+		go func(r *CertWebHook) {
+			defer wg.Done()
+			if err := r.Bootstrap(ctx); err != nil {
+				t.Errorf("%s: %v", r.Identity, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if creates := atomic.LoadInt32(&creates); creates != 1 {
+		t.Errorf("got %d secrets created, want 1", creates)
+	}
+	secret, err := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range replicas {
+		crt, _ := ioutil.ReadFile(path.Join(r.CertDir, "tls.crt"))
+		key, _ := ioutil.ReadFile(path.Join(r.CertDir, "tls.key"))
+		if !bytes.Equal(crt, secret.Data[certKey]) || !bytes.Equal(key, secret.Data[keyKey]) {
+			t.Errorf("%s did not write the certificate of the secret", r.Identity)
+		}
+	}
+	lease, err := cli.CoordinationV1().Leases("default").Get(context.TODO(), "webhook-cert-lock", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("lease is still held by %s", *lease.Spec.HolderIdentity)
+	}
+}
+
+func TestCertWebHook_RunLeadingWaitsForLead(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		cli := fake.NewSimpleClientset()
+		c := &CertWebHook{Namespace: "default", SecretName: "webhook-cert", Identity: "replica", client: cli}
+		var started, finished int32
+		lead := func() error {
+			atomic.StoreInt32(&started, 1)
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+			return nil
+		}
+		// follow finishes as soon as the lease is taken, racing with the start of lead
+		follow := func(ctx context.Context) error {
+			for {
+				if _, err := cli.CoordinationV1().Leases("default").Get(ctx, "webhook-cert-lock", v1.GetOptions{}); err == nil {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Millisecond):
+				}
+			}
+		}
+		if err := c.runLeading(context.Background(), lead, follow); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&started) != atomic.LoadInt32(&finished) {
+			t.Fatal("runLeading returned while lead was running")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if atomic.LoadInt32(&started) != atomic.LoadInt32(&finished) {
+			t.Fatal("lead started after runLeading returned")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"fmt"
+	"io"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registry holds the WebhookObjects registered by Init, keyed by the server they are registered on.
+var registry struct {
+	mu      sync.Mutex
+	objects map[*webhook.Server][]*WebhookObject
+}
+
+func register(wko *WebhookObject) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.objects == nil {
+		registry.objects = map[*webhook.Server][]*WebhookObject{}
+	}
+	registry.objects[wko.WK] = append(registry.objects[wko.WK], wko)
+}
+
+// RegisteredWebhookObjects returns the WebhookObjects registered on server by Init, in order.
+func RegisteredWebhookObjects(server *webhook.Server) []*WebhookObject {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]*WebhookObject(nil), registry.objects[server]...)
+}
+
+// ManifestOptions names the webhook configurations written by WriteManifests.
+type ManifestOptions struct {
+	// ValidatingName is the ValidatingWebhookConfiguration name, validating-webhook-configuration by default.
+	ValidatingName string
+	// MutatingName is the MutatingWebhookConfiguration name, mutating-webhook-configuration by default.
+	MutatingName string
+	// Namespace and ServiceName are the service of the webhooks, system/webhook-service by default.
+	Namespace   string
+	ServiceName string
+	// Domain is the suffix of the webhook names, kb.io by default, e.g. vhorizontalpodautoscaler.kb.io.
+	Domain string
+	// Scheme finds the GVK of the objects without a Scheme, the client-go scheme by default.
+	Scheme *runtime.Scheme
+	// Server selects the WebhookObjects registered on it when no objects are given.
+	Server *webhook.Server
+}
+
+func (o *ManifestOptions) setDefaults() {
+	if o.ValidatingName == "" {
+		o.ValidatingName = "validating-webhook-configuration"
+	}
+	if o.MutatingName == "" {
+		o.MutatingName = "mutating-webhook-configuration"
+	}
+	if o.Namespace == "" {
+		o.Namespace = "system"
+	}
+	if o.ServiceName == "" {
+		o.ServiceName = "webhook-service"
+	}
+	if o.Domain == "" {
+		o.Domain = "kb.io"
+	}
+}
+
+// WebhookDefinitions describes the validating and mutating webhooks of objs, the ones registered on opts.Server
+// if objs is empty. They can be set in WebHook.Validating and WebHook.Mutating so CertWebHook applies what the
+// code serves. Webhooks are named after the kind of their object, so two objects of one kind are an error.
+func WebhookDefinitions(opts ManifestOptions, objs ...*WebhookObject) (validating, mutating []WebhookDefinition, err error) {
+	opts.setDefaults()
+	if len(objs) == 0 {
+		objs = RegisteredWebhookObjects(opts.Server)
+	}
+	kinds := map[string]schema.GroupVersionKind{}
+	for _, wko := range objs {
+		if !wko.validating && !wko.defaulting {
+			continue
+		}
+		scheme := opts.Scheme
+		if wko.Scheme != nil {
+			scheme = wko.Scheme
+		}
+		gvk, err := apiutil.GVKForObject(wko.Obj, schemeOrDefault(scheme))
+		if err != nil {
+			return nil, nil, fmt.Errorf("find the kind of webhook object failed %s", err)
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		resources := []string{plural.Resource}
+		for name := range wko.SubResources {
+			resources = append(resources, plural.Resource+"/"+name)
+		}
+		sort.Strings(resources[1:])
+		operations := wko.Operations
+		if len(operations) == 0 {
+			operations = []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}
+		}
+		failurePolicy := wko.FailurePolicy
+		if failurePolicy == "" {
+			failurePolicy = admissionregistrationv1.Fail
+		}
+		d := WebhookDefinition{
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: operations,
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{gvk.Group},
+					APIVersions: []string{gvk.Version},
+					Resources:   resources,
+				},
+			}},
+			FailurePolicy: failurePolicy,
+		}
+		name := strings.ToLower(gvk.Kind) + "." + opts.Domain
+		if other, ok := kinds[name]; ok {
+			return nil, nil, fmt.Errorf("webhooks of %s and %s are both named after the kind %s", other, gvk, gvk.Kind)
+		}
+		kinds[name] = gvk
+		if wko.validating {
+			d.Name, d.Path = "v"+name, wko.ValidatingPath
+			validating = append(validating, d)
+		}
+		if wko.defaulting {
+			d.Name, d.Path = "m"+name, wko.DefaultingPath
+			mutating = append(mutating, d)
+		}
+	}
+	return validating, mutating, nil
+}
+
+// WriteManifests writes the MutatingWebhookConfiguration and ValidatingWebhookConfiguration YAML
+// of objs to w, the ones registered on opts.Server if objs is empty. The caBundle is left to CertWebHook.
+func WriteManifests(w io.Writer, opts ManifestOptions, objs ...*WebhookObject) error {
+	opts.setDefaults()
+	validating, mutating, err := WebhookDefinitions(opts, objs...)
+	if err != nil {
+		return err
+	}
+	c := &CertWebHook{Namespace: opts.Namespace, ServiceName: opts.ServiceName}
+	var docs []interface{}
+	if len(mutating) != 0 {
+		docs = append(docs, c.mutatingConfiguration(WebHook{MutatingName: opts.MutatingName, Mutating: mutating}, ""))
+	}
+	if len(validating) != 0 {
+		docs = append(docs, c.validatingConfiguration(WebHook{ValidatingName: opts.ValidatingName, Validating: validating}, ""))
+	}
+	for _, doc := range docs {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestWriteManifests(t *testing.T) {
+	server := &webhook.Server{}
+	configMaps := &WebhookObject{
+		WK:             server,
+		Webhook:        &configMapWebhook{},
+		Obj:            &corev1.ConfigMap{},
+		ValidatingPath: "/validate--v1-configmap",
+		DefaultingPath: "/mutate--v1-configmap",
+		Operations:     []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Delete},
+		FailurePolicy:  admissionregistrationv1.Ignore,
+	}
+	if err := configMaps.Init(); err != nil {
+		t.Fatal(err)
+	}
+	deployments := &WebhookObject{
+		WK:             server,
+		Webhook:        errorValidator{},
+		Obj:            &appsv1.Deployment{},
+		ValidatingPath: "/validate-apps-v1-deployment",
+		SubResources:   map[string]SubResource{"scale": {Webhook: scaleValidator{}}},
+	}
+	if err := deployments.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := RegisteredWebhookObjects(server)
+	if len(registered) != 2 || registered[0] != configMaps || registered[1] != deployments {
+		t.Fatal("Init did not register the webhook objects")
+	}
+	other := &WebhookObject{WK: &webhook.Server{}, Webhook: errorValidator{}, Obj: &appsv1.Deployment{}}
+	if err := other.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if registered := RegisteredWebhookObjects(server); len(registered) != 2 {
+		t.Errorf("got %d webhook objects registered, the ones of another server are shared", len(registered))
+	}
+
+	b := &bytes.Buffer{}
+	if err := WriteManifests(b, ManifestOptions{Namespace: "default", ServiceName: "service", Server: server}); err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(b.String(), "---\n")[1:]
+	if len(docs) != 2 {
+		t.Fatalf("got manifests\n%s", b)
+	}
+	mwebhook := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := yaml.Unmarshal([]byte(docs[0]), mwebhook); err != nil {
+		t.Fatal(err)
+	}
+	vwebhook := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := yaml.Unmarshal([]byte(docs[1]), vwebhook); err != nil {
+		t.Fatal(err)
+	}
+	if mwebhook.Name != "mutating-webhook-configuration" || len(mwebhook.Webhooks) != 1 {
+		t.Fatalf("got mutating configuration %+v", mwebhook)
+	}
+	mw := mwebhook.Webhooks[0]
+	if mw.Name != "mconfigmap.kb.io" || *mw.ClientConfig.Service.Path != "/mutate--v1-configmap" ||
+		mw.ClientConfig.Service.Name != "service" || *mw.FailurePolicy != admissionregistrationv1.Ignore {
+		t.Errorf("got mutating webhook %+v", mw)
+	}
+	if !reflect.DeepEqual(mw.Rules[0].Operations, configMaps.Operations) || mw.Rules[0].Resources[0] != "configmaps" {
+		t.Errorf("got rules %+v", mw.Rules)
+	}
+
+	if vwebhook.Name != "validating-webhook-configuration" || len(vwebhook.Webhooks) != 2 {
+		t.Fatalf("got validating configuration %+v", vwebhook)
+	}
+	vw := vwebhook.Webhooks[1]
+	if vw.Name != "vdeployment.kb.io" || *vw.ClientConfig.Service.Path != "/validate-apps-v1-deployment" ||
+		*vw.FailurePolicy != admissionregistrationv1.Fail || len(vw.ClientConfig.CABundle) != 0 {
+		t.Errorf("got validating webhook %+v", vw)
+	}
+	rule := vw.Rules[0]
+	if rule.APIGroups[0] != "apps" || rule.APIVersions[0] != "v1" ||
+		!reflect.DeepEqual(rule.Resources, []string{"deployments", "deployments/scale"}) ||
+		!reflect.DeepEqual(rule.Operations, []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}) {
+		t.Errorf("got rules %+v", vw.Rules)
+	}
+}
+
+func TestWebhookDefinitions_KindCollision(t *testing.T) {
+	server := &webhook.Server{}
+	for _, obj := range []runtime.Object{&cronV1{}, &cronV2{}} {
+		wko := &WebhookObject{WK: server, Webhook: errorValidator{}, Obj: obj, Scheme: newCronScheme()}
+		if err := wko.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := WebhookDefinitions(ManifestOptions{Server: server}); err == nil {
+		t.Error("two versions of the kind Cron got the same webhook name")
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/http"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"sync"
+)
+
+// NamedDefaulter is a CustomDefaulter run in a chain by ChainDefaultingWebhookFor.
+// Name reports the fields it changed, so it must be a valid audit annotation key, e.g. "sidecar-injection".
+type NamedDefaulter struct {
+	Name      string
+	Defaulter CustomDefaulter
+}
+
+// ChainDefaultingWebhookFor creates a new Webhook running defaulters in order on one object of the type of obj.
+// It answers with a single patch, and the audit annotation of each defaulter lists the paths it changed.
+func ChainDefaultingWebhookFor(obj runtime.Object, defaulters ...NamedDefaulter) *admission.Webhook {
+	return &admission.Webhook{
+		Handler: &chainMutatingHandler{object: obj, defaulters: defaulters},
+	}
+}
+
+type chainMutatingHandler struct {
+	object     runtime.Object
+	defaulters []NamedDefaulter
+	decoder    *admission.Decoder
+	scheme     *runtime.Scheme
+}
+
+var _ admission.DecoderInjector = &chainMutatingHandler{}
+
+// InjectDecoder injects the decoder into a chainMutatingHandler.
+func (h *chainMutatingHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+// InjectScheme injects the scheme used to find the type of untyped requests into a chainMutatingHandler.
+func (h *chainMutatingHandler) InjectScheme(s *runtime.Scheme) error {
+	h.scheme = s
+	return nil
+}
+
+// Handle handles admission requests.
+func (h *chainMutatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ctx, warnings := newContextWithWarnings(NewContextWithRequest(ctx, req))
+	return withWarnings(h.handle(ctx, req), warnings.get())
+}
+
+func (h *chainMutatingHandler) handle(ctx context.Context, req admission.Request) admission.Response {
+	// Get the object in the request
+	obj := newRequestObject(h.scheme, h.object, req)
+	err := h.decoder.Decode(req, obj)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	current, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	changed := map[string]string{}
+	for _, d := range h.defaulters {
+		// Default the object
+		err = d.Defaulter.Default(ctx, obj)
+		if err != nil {
+			return deniedFromError(req, fmt.Errorf("%s: %w", d.Name, err))
+		}
+		marshalled, err := json.Marshal(obj)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		patches, err := jsonpatch.CreatePatch(current, marshalled)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if len(patches) != 0 {
+			paths := make([]string, 0, len(patches))
+			for _, p := range patches {
+				paths = append(paths, p.Path)
+			}
+			changed[d.Name] = strings.Join(paths, ",")
+		}
+		current = marshalled
+	}
+
+	// Create the patch
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, current)
+	if len(changed) != 0 {
+		resp.AuditAnnotations = changed
+	}
+	return resp
+}
+
+// defaulterFunc runs the Defaulters it returns as a CustomDefaulter.
+type defaulterFunc func() Defaulter
+
+func (f defaulterFunc) Default(ctx context.Context, obj runtime.Object) error {
+	defaulter := f()
+	defaulter.IntoRuntimeObject(obj)
+	defaulter.Default()
+	for _, w := range warningsOf(defaulter) {
+		AddWarning(ctx, w)
+	}
+	out := defaulter.OutRuntimeObject()
+	if out == obj {
+		return nil
+	}
+	// copy the defaulted object back into obj
+	dst := reflect.ValueOf(obj).Elem()
+	if src := reflect.ValueOf(out); src.Type() == reflect.TypeOf(obj) {
+		dst.Set(src.Elem())
+		return nil
+	}
+	dst.Set(reflect.Zero(dst.Type()))
+	return JsonConvert(out, obj)
+}
+
+// NamedValidator is a CustomValidator run by AllValidatingWebhookFor or ParallelValidatingWebhookFor.
+// Name prefixes the errors and warnings it raises.
+type NamedValidator struct {
+	Name      string
+	Validator CustomValidator
+}
+
+// AllValidatingWebhookFor creates a new Webhook running all validators in order on objects of the type of obj.
+// It denies a request once with the errors of every failed validator, prefixed with its name.
+func AllValidatingWebhookFor(obj runtime.Object, validators ...NamedValidator) *admission.Webhook {
+	return CustomValidatingWebhookFor(obj, allValidators{validators: validators})
+}
+
+// ParallelValidatingWebhookFor is like AllValidatingWebhookFor, but runs the validators in parallel.
+// They are given the same objects at once, so they must not change them.
+func ParallelValidatingWebhookFor(obj runtime.Object, validators ...NamedValidator) *admission.Webhook {
+	return CustomValidatingWebhookFor(obj, allValidators{validators: validators, parallel: true})
+}
+
+// allValidators is a CustomValidator running all validators and aggregating their errors and warnings.
+type allValidators struct {
+	validators []NamedValidator
+	parallel   bool
+}
+
+func (a allValidators) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return a.run(ctx, func(ctx context.Context, v CustomValidator) error {
+		return v.ValidateCreate(ctx, obj)
+	})
+}
+
+func (a allValidators) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return a.run(ctx, func(ctx context.Context, v CustomValidator) error {
+		return v.ValidateUpdate(ctx, oldObj, newObj)
+	})
+}
+
+func (a allValidators) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return a.run(ctx, func(ctx context.Context, v CustomValidator) error {
+		return v.ValidateDelete(ctx, obj)
+	})
+}
+
+func (a allValidators) supportsConnect() bool {
+	for _, v := range a.validators {
+		if supportsOperation(admissionv1.Connect, v.Validator) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a allValidators) ValidateConnect(ctx context.Context, options runtime.Object) error {
+	return a.run(ctx, func(ctx context.Context, v CustomValidator) error {
+		if cv, ok := v.(CustomConnectValidator); ok {
+			return cv.ValidateConnect(ctx, options)
+		}
+		return nil
+	})
+}
+
+// run calls validate for every validator, each with its own warnings, and merges the results in order.
+func (a allValidators) run(ctx context.Context, validate func(context.Context, CustomValidator) error) error {
+	errs := make([]error, len(a.validators))
+	warnings := make([]*warningList, len(a.validators))
+	call := func(i int) {
+		var vctx context.Context
+		vctx, warnings[i] = newContextWithWarnings(ctx)
+		errs[i] = validate(vctx, a.validators[i].Validator)
+	}
+	if a.parallel {
+		var wg sync.WaitGroup
+		for i := range a.validators {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				call(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range a.validators {
+			call(i)
+		}
+	}
+
+	var failed []error
+	var fieldErrs field.ErrorList
+	allFieldErrs := true
+	for i, v := range a.validators {
+		for _, w := range warnings[i].get() {
+			AddWarning(ctx, fmt.Sprintf("%s: %s", v.Name, w))
+		}
+		if errs[i] == nil {
+			continue
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", v.Name, errs[i]))
+		list := fieldErrors(errs[i])
+		if len(list) == 0 {
+			allFieldErrs = false
+		}
+		for _, fe := range list {
+			named := *fe
+			named.Detail = fmt.Sprintf("%s: %s", v.Name, fe.Detail)
+			fieldErrs = append(fieldErrs, &named)
+		}
+	}
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(failed) == 1:
+		return failed[0]
+	case allFieldErrs:
+		// keep the causes of all validators in one Invalid status
+		return fieldErrs.ToAggregate()
+	}
+	return utilerrors.NewAggregate(failed)
+}
+
+// validatorFunc runs the Validators it returns as a CustomValidator.
+type validatorFunc func() Validator
+
+func (f validatorFunc) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	validator := f()
+	validator.IntoRuntimeObject(obj)
+	return addWarnings(ctx, validator, validator.ValidateCreate())
+}
+
+func (f validatorFunc) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	validator := f()
+	validator.IntoRuntimeObject(newObj)
+	oldValidator := f()
+	oldValidator.IntoRuntimeObject(oldObj)
+	return addWarnings(ctx, validator, validator.ValidateUpdate(oldValidator.OutRuntimeObject()))
+}
+
+func (f validatorFunc) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	validator := f()
+	validator.IntoRuntimeObject(obj)
+	return addWarnings(ctx, validator, validator.ValidateDelete())
+}
+
+func (f validatorFunc) supportsConnect() bool {
+	_, ok := f().(ConnectValidator)
+	return ok
+}
+
+func (f validatorFunc) ValidateConnect(ctx context.Context, options runtime.Object) error {
+	validator := f()
+	if cv, ok := validator.(ConnectValidator); ok {
+		return addWarnings(ctx, validator, cv.ValidateConnect(options))
+	}
+	return nil
+}
+
+// addWarnings adds the warnings of validator to ctx and returns err.
+func addWarnings(ctx context.Context, validator Validator, err error) error {
+	for _, w := range warningsOf(validator) {
+		AddWarning(ctx, w)
+	}
+	return err
+}
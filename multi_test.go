@@ -0,0 +1,256 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type defaulterFn func(ctx context.Context, obj runtime.Object) error
+
+func (f defaulterFn) Default(ctx context.Context, obj runtime.Object) error {
+	return f(ctx, obj)
+}
+
+func TestChainMutatingHandler(t *testing.T) {
+	labels := defaulterFn(func(ctx context.Context, obj runtime.Object) error {
+		obj.(*corev1.Pod).Labels = map[string]string{"team": "infra"}
+		return nil
+	})
+	sidecar := defaulterFn(func(ctx context.Context, obj runtime.Object) error {
+		pod := obj.(*corev1.Pod)
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "proxy"})
+		return nil
+	})
+	// limits reads the sidecar added before it
+	limits := defaulterFn(func(ctx context.Context, obj runtime.Object) error {
+		pod := obj.(*corev1.Pod)
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == "sidecar" {
+				pod.Spec.Containers[i].Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+			}
+		}
+		return nil
+	})
+	noop := defaulterFn(func(ctx context.Context, obj runtime.Object) error {
+		return nil
+	})
+	wh := ChainDefaultingWebhookFor(&corev1.Pod{},
+		NamedDefaulter{Name: "labels", Defaulter: labels},
+		NamedDefaulter{Name: "sidecar", Defaulter: sidecar},
+		NamedDefaulter{Name: "limits", Defaulter: limits},
+		NamedDefaulter{Name: "noop", Defaulter: noop},
+	)
+	injectDecoder(t, wh)
+	raw, _ := json.Marshal(&corev1.Pod{
+		TypeMeta:   v1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: v1.ObjectMeta{Name: "pod"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+	})
+	resp := wh.Handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed {
+		t.Fatalf("got response %v", resp.Result)
+	}
+	paths := map[string]bool{}
+	for _, p := range resp.Patches {
+		paths[p.Path] = true
+	}
+	if !paths["/metadata/labels"] || !paths["/spec/containers/1"] {
+		t.Errorf("got patches %v", resp.Patches)
+	}
+	want := map[string]string{
+		"labels":  "/metadata/labels",
+		"sidecar": "/spec/containers/1",
+		"limits":  "/spec/containers/1/resources/limits",
+	}
+	if len(resp.AuditAnnotations) != len(want) {
+		t.Errorf("got audit annotations %v, want %v", resp.AuditAnnotations, want)
+	}
+	for k, v := range want {
+		if resp.AuditAnnotations[k] != v {
+			t.Errorf("got audit annotation %s=%s, want %s", k, resp.AuditAnnotations[k], v)
+		}
+	}
+}
+
+type validatorFn func(ctx context.Context, obj runtime.Object) error
+
+func (f validatorFn) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return f(ctx, obj)
+}
+func (f validatorFn) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return f(ctx, newObj)
+}
+func (f validatorFn) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return f(ctx, obj)
+}
+
+func TestAllValidatingHandler(t *testing.T) {
+	image := validatorFn(func(ctx context.Context, obj runtime.Object) error {
+		AddWarning(ctx, "latest tag is deprecated")
+		return field.Invalid(field.NewPath("spec", "containers").Index(0).Child("image"), "app", "image must be pinned")
+	})
+	labels := validatorFn(func(ctx context.Context, obj runtime.Object) error {
+		return field.Required(field.NewPath("metadata", "labels", "team"), "team label is required")
+	})
+	plain := validatorFn(func(ctx context.Context, obj runtime.Object) error {
+		return fmt.Errorf("quota exceeded")
+	})
+	ok := validatorFn(func(ctx context.Context, obj runtime.Object) error {
+		return nil
+	})
+	tests := []struct {
+		name        string
+		parallel    bool
+		validators  []NamedValidator
+		wantAllowed bool
+		wantCauses  []string
+		wantReason  string
+	}{
+		{name: "allowed", validators: []NamedValidator{{Name: "ok", Validator: ok}}, wantAllowed: true},
+		{name: "field errors", validators: []NamedValidator{{Name: "image", Validator: image}, {Name: "ok", Validator: ok}, {Name: "labels", Validator: labels}},
+			wantCauses: []string{"image: image must be pinned", "labels: team label is required"}},
+		{name: "field errors parallel", parallel: true, validators: []NamedValidator{{Name: "image", Validator: image}, {Name: "labels", Validator: labels}},
+			wantCauses: []string{"image: image must be pinned", "labels: team label is required"}},
+		{name: "mixed errors", validators: []NamedValidator{{Name: "image", Validator: image}, {Name: "quota", Validator: plain}},
+			wantReason: `[image: spec.containers[0].image: Invalid value: "app": image must be pinned, quota: quota exceeded]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := AllValidatingWebhookFor(&corev1.Pod{}, tt.validators...)
+			if tt.parallel {
+				wh = ParallelValidatingWebhookFor(&corev1.Pod{}, tt.validators...)
+			}
+			injectDecoder(t, wh)
+			raw, _ := json.Marshal(&corev1.Pod{TypeMeta: v1.TypeMeta{APIVersion: "v1", Kind: "Pod"}, ObjectMeta: v1.ObjectMeta{Name: "pod"}})
+			resp := wh.Handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Kind:      v1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      "pod",
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != tt.wantAllowed {
+				t.Fatalf("got allowed %v, want %v: %v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+			if tt.wantAllowed {
+				return
+			}
+			if tt.wantReason != "" && string(resp.Result.Reason) != tt.wantReason {
+				t.Errorf("got reason %s, want %s", resp.Result.Reason, tt.wantReason)
+			}
+			if tt.wantCauses != nil {
+				var causes []string
+				for _, c := range resp.Result.Details.Causes {
+					causes = append(causes, c.Message)
+				}
+				if len(causes) != len(tt.wantCauses) {
+					t.Fatalf("got causes %v, want %v", causes, tt.wantCauses)
+				}
+				for i := range causes {
+					if !strings.HasSuffix(causes[i], tt.wantCauses[i]) {
+						t.Errorf("got cause %s, want %s", causes[i], tt.wantCauses[i])
+					}
+				}
+			}
+			if len(resp.Warnings) != 1 || resp.Warnings[0] != "image: latest tag is deprecated" {
+				t.Errorf("got warnings %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+type connectValidatorFn func(ctx context.Context, options runtime.Object) error
+
+func (f connectValidatorFn) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+func (f connectValidatorFn) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return nil
+}
+func (f connectValidatorFn) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+func (f connectValidatorFn) ValidateConnect(ctx context.Context, options runtime.Object) error {
+	return f(ctx, options)
+}
+
+func TestAllValidatingHandler_Connect(t *testing.T) {
+	ok := validatorFn(func(ctx context.Context, obj runtime.Object) error {
+		return nil
+	})
+	noStdin := connectValidatorFn(func(ctx context.Context, options runtime.Object) error {
+		if options.(*corev1.PodExecOptions).Stdin {
+			return fmt.Errorf("stdin is not allowed")
+		}
+		return nil
+	})
+	tests := []struct {
+		name        string
+		parallel    bool
+		validators  []NamedValidator
+		options     *corev1.PodExecOptions
+		wantAllowed bool
+	}{
+		{name: "unsupported", validators: []NamedValidator{{Name: "ok", Validator: ok}}, options: &corev1.PodExecOptions{}},
+		{name: "unsupported parallel", parallel: true, validators: []NamedValidator{{Name: "ok", Validator: ok}}, options: &corev1.PodExecOptions{}},
+		{name: "legacy unsupported", validators: []NamedValidator{{Name: "legacy", Validator: validatorFunc(func() Validator {
+			return &configMapWebhook{}
+		})}}, options: &corev1.PodExecOptions{}},
+		{name: "supported allowed", validators: []NamedValidator{{Name: "ok", Validator: ok}, {Name: "stdin", Validator: noStdin}},
+			options: &corev1.PodExecOptions{}, wantAllowed: true},
+		{name: "supported denied", validators: []NamedValidator{{Name: "ok", Validator: ok}, {Name: "stdin", Validator: noStdin}},
+			options: &corev1.PodExecOptions{Stdin: true}},
+		{name: "legacy supported", validators: []NamedValidator{{Name: "legacy", Validator: validatorFunc(func() Validator {
+			return &legacyExecValidator{}
+		})}}, options: &corev1.PodExecOptions{}, wantAllowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := AllValidatingWebhookFor(&corev1.Pod{}, tt.validators...)
+			if tt.parallel {
+				wh = ParallelValidatingWebhookFor(&corev1.Pod{}, tt.validators...)
+			}
+			wh.Handler.(*customValidatingHandler).denyUnsupported = true
+			injectDecoder(t, wh)
+			tt.options.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodExecOptions"))
+			raw, _ := json.Marshal(tt.options)
+			resp := wh.Handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Connect,
+				Resource:    v1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				SubResource: "exec",
+				Object:      runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("got allowed %v, want %v: %v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+	"time"
+)
+
+// Actions of a SecretPlan.
+const (
+	SecretCreate = "create"
+	SecretReuse  = "reuse"
+	SecretRenew  = "renew"
+	SecretUpdate = "update"
+)
+
+// Formats of Plan.Write.
+const (
+	PlanText = "text"
+	PlanJSON = "json"
+)
+
+// Actions of a ConfigurationPlan built from WebhookDefinitions, the others are only patched.
+const (
+	ConfigurationCreate = "create"
+	ConfigurationApply  = "apply"
+)
+
+// generatedCABundle stands for a caBundle only known once the CA is generated.
+const generatedCABundle = "<generated>"
+
+// Plan is what Generator would change, computed by DryRun without changing anything.
+type Plan struct {
+	Secret         SecretPlan          `json:"secret"`
+	CSR            *CSRPlan            `json:"csr,omitempty"`
+	Configurations []ConfigurationPlan `json:"configurations"`
+}
+
+// SecretPlan tells whether the Secret is created, reused, renewed or updated.
+type SecretPlan struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+}
+
+// CSRPlan is the CertificateSigningRequest submitted.
+type CSRPlan struct {
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion"`
+	SignerName string `json:"signerName,omitempty"`
+}
+
+// ConfigurationPlan lists the changed entries of a webhook configuration or CRD, none when it is unchanged.
+// Configurations built from WebhookDefinitions are created or applied, only the service, caBundle and selectors of their entries are compared.
+type ConfigurationPlan struct {
+	Kind    string      `json:"kind"`
+	Name    string      `json:"name"`
+	Action  string      `json:"action,omitempty"`
+	Entries []EntryPlan `json:"entries,omitempty"`
+}
+
+// EntryPlan lists the changed fields of a webhook entry.
+type EntryPlan struct {
+	Name    string   `json:"name"`
+	Changes []Change `json:"changes"`
+}
+
+// Change is the old and new value of a field, caBundles are shown by their SHA-256 fingerprint.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// DryRun computes what Generator would change without changing anything.
+func (c *CertWebHook) DryRun() (*Plan, error) {
+	if err := c.validateWebHooks(); err != nil {
+		return nil, err
+	}
+	plan := &Plan{Secret: SecretPlan{Namespace: c.Namespace, Name: c.SecretName}}
+	caBundle, err := c.planSecret(plan)
+	if err != nil {
+		return nil, err
+	}
+	for _, wk := range c.WebHook {
+		if wk.ValidatingName != "" {
+			cp := ConfigurationPlan{Kind: "ValidatingWebhookConfiguration", Name: wk.ValidatingName}
+			vwebhook, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), wk.ValidatingName, v1.GetOptions{})
+			if errors.IsNotFound(err) && len(wk.Validating) != 0 {
+				vwebhook, err = &admissionregistrationv1.ValidatingWebhookConfiguration{}, nil
+				cp.Action = ConfigurationCreate
+			}
+			if err != nil {
+				return nil, err
+			}
+			patched := vwebhook.DeepCopy()
+			c.injectValidating(wk, patched, caBundle)
+			if len(wk.Validating) != 0 {
+				patched = c.validatingConfiguration(wk, caBundle)
+				if cp.Action == "" {
+					cp.Action = ConfigurationApply
+				}
+			}
+			for _, after := range patched.Webhooks {
+				var before admissionregistrationv1.ValidatingWebhook
+				for _, w := range vwebhook.Webhooks {
+					if w.Name == after.Name {
+						before = w
+					}
+				}
+				cp.Entries = appendEntryPlan(cp.Entries, after.Name, before.ClientConfig, after.ClientConfig,
+					before.NamespaceSelector, after.NamespaceSelector, before.ObjectSelector, after.ObjectSelector)
+			}
+			plan.Configurations = append(plan.Configurations, cp)
+		}
+		if wk.MutatingName != "" {
+			cp := ConfigurationPlan{Kind: "MutatingWebhookConfiguration", Name: wk.MutatingName}
+			mwebhook, err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), wk.MutatingName, v1.GetOptions{})
+			if errors.IsNotFound(err) && len(wk.Mutating) != 0 {
+				mwebhook, err = &admissionregistrationv1.MutatingWebhookConfiguration{}, nil
+				cp.Action = ConfigurationCreate
+			}
+			if err != nil {
+				return nil, err
+			}
+			patched := mwebhook.DeepCopy()
+			c.injectMutating(wk, patched, caBundle)
+			if len(wk.Mutating) != 0 {
+				patched = c.mutatingConfiguration(wk, caBundle)
+				if cp.Action == "" {
+					cp.Action = ConfigurationApply
+				}
+			}
+			for _, after := range patched.Webhooks {
+				var before admissionregistrationv1.MutatingWebhook
+				for _, w := range mwebhook.Webhooks {
+					if w.Name == after.Name {
+						before = w
+					}
+				}
+				cp.Entries = appendEntryPlan(cp.Entries, after.Name, before.ClientConfig, after.ClientConfig,
+					before.NamespaceSelector, after.NamespaceSelector, before.ObjectSelector, after.ObjectSelector)
+			}
+			plan.Configurations = append(plan.Configurations, cp)
+		}
+		if wk.CRDName != "" {
+			crd, err := c.crdClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), wk.CRDName, v1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			patched := crd.DeepCopy()
+			if err := c.injectCRD(patched, caBundle); err != nil {
+				return nil, err
+			}
+			cp := ConfigurationPlan{Kind: "CustomResourceDefinition", Name: wk.CRDName}
+			before, after := crd.Spec.Conversion.Webhook.ClientConfig, patched.Spec.Conversion.Webhook.ClientConfig
+			var changes []Change
+			if after.Service != nil {
+				var name, namespace string
+				if before.Service != nil {
+					name, namespace = before.Service.Name, before.Service.Namespace
+				}
+				changes = diffField(changes, "service.name", name, after.Service.Name)
+				changes = diffField(changes, "service.namespace", namespace, after.Service.Namespace)
+			}
+			changes = diffField(changes, "caBundle", fingerprint(before.CABundle), fingerprint(after.CABundle))
+			if len(changes) != 0 {
+				cp.Entries = append(cp.Entries, EntryPlan{Name: "conversion", Changes: changes})
+			}
+			plan.Configurations = append(plan.Configurations, cp)
+		}
+		if wk.APIServiceName != "" {
+			apiService, err := c.dynamicClient.Resource(apiServiceResource).Get(context.TODO(), wk.APIServiceName, v1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			patched := apiService.DeepCopy()
+			if err := c.injectAPIService(patched, caBundle); err != nil {
+				return nil, err
+			}
+			cp := ConfigurationPlan{Kind: "APIService", Name: wk.APIServiceName}
+			var changes []Change
+			for _, f := range []string{"name", "namespace"} {
+				before, _, _ := unstructured.NestedString(apiService.Object, "spec", "service", f)
+				after, _, _ := unstructured.NestedString(patched.Object, "spec", "service", f)
+				changes = diffField(changes, "service."+f, before, after)
+			}
+			changes = diffField(changes, "caBundle", fingerprint(apiServiceCABundle(apiService)), fingerprint(apiServiceCABundle(patched)))
+			if len(changes) != 0 {
+				cp.Entries = append(cp.Entries, EntryPlan{Name: "service", Changes: changes})
+			}
+			plan.Configurations = append(plan.Configurations, cp)
+		}
+	}
+	return plan, nil
+}
+
+// planSecret fills in the Secret and CSR of plan as Generator would change them and returns the caBundle injected.
+func (c *CertWebHook) planSecret(plan *Plan) (string, error) {
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if c.SelfSigned {
+		switch {
+		case err != nil:
+			plan.Secret.Action = SecretCreate
+		case len(secret.Data[caKeyKey]) == 0 || len(secret.Data[certKey]) == 0:
+			plan.Secret.Action = SecretUpdate
+		case !c.needsRenewal(secret, time.Now()):
+			plan.Secret.Action = SecretReuse
+			return string(secret.Data[caBundleKey]), nil
+		default:
+			plan.Secret.Action = SecretRenew
+			ca, err := ParseCertificate(secret.Data[caBundleKey])
+			if err == nil && time.Now().Before(c.renewTime(ca)) {
+				return string(secret.Data[caBundleKey]), nil
+			}
+		}
+		return generatedCABundle, nil
+	}
+
+	// the CSR is submitted again on every run
+	plan.Secret.Action = SecretUpdate
+	if err != nil {
+		plan.Secret.Action = SecretCreate
+	}
+	plan.CSR = &CSRPlan{Name: c.CsrName, APIVersion: certificatesv1beta1.SchemeGroupVersion.String(), SignerName: c.SignerName}
+	v1Available, err := c.csrV1Available()
+	if err != nil {
+		return "", err
+	}
+	if v1Available {
+		plan.CSR.APIVersion = certificatesv1.SchemeGroupVersion.String()
+		if err := c.checkSignerName(); err != nil {
+			return "", err
+		}
+	}
+	return c.signerCABundle()
+}
+
+// appendEntryPlan appends the changes of a webhook entry to entries, unless it is unchanged.
+func appendEntryPlan(entries []EntryPlan, name string, oldConfig, newConfig admissionregistrationv1.WebhookClientConfig,
+	oldNamespaceSelector, newNamespaceSelector, oldObjectSelector, newObjectSelector *v1.LabelSelector) []EntryPlan {
+	var changes []Change
+	changes = diffField(changes, "service.name", serviceName(oldConfig.Service), serviceName(newConfig.Service))
+	changes = diffField(changes, "service.namespace", serviceNamespace(oldConfig.Service), serviceNamespace(newConfig.Service))
+	changes = diffField(changes, "service.path", servicePath(oldConfig.Service), servicePath(newConfig.Service))
+	changes = diffField(changes, "caBundle", fingerprint(oldConfig.CABundle), fingerprint(newConfig.CABundle))
+	changes = diffField(changes, "namespaceSelector", formatSelector(oldNamespaceSelector), formatSelector(newNamespaceSelector))
+	changes = diffField(changes, "objectSelector", formatSelector(oldObjectSelector), formatSelector(newObjectSelector))
+	if len(changes) == 0 {
+		return entries
+	}
+	return append(entries, EntryPlan{Name: name, Changes: changes})
+}
+
+func diffField(changes []Change, field, before, after string) []Change {
+	if before == after {
+		return changes
+	}
+	return append(changes, Change{Field: field, Old: before, New: after})
+}
+
+func serviceName(s *admissionregistrationv1.ServiceReference) string {
+	if s == nil {
+		return ""
+	}
+	return s.Name
+}
+
+func serviceNamespace(s *admissionregistrationv1.ServiceReference) string {
+	if s == nil {
+		return ""
+	}
+	return s.Namespace
+}
+
+func servicePath(s *admissionregistrationv1.ServiceReference) string {
+	if s == nil || s.Path == nil {
+		return ""
+	}
+	return *s.Path
+}
+
+// fingerprint shortens caBundle to the start of its SHA-256 sum.
+func fingerprint(caBundle []byte) string {
+	switch strings.TrimSpace(string(caBundle)) {
+	case "":
+		return "<empty>"
+	case generatedCABundle:
+		return generatedCABundle
+	}
+	sum := sha256.Sum256(caBundle)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+func formatSelector(selector *v1.LabelSelector) string {
+	if selector == nil {
+		return "<none>"
+	}
+	return v1.FormatLabelSelector(selector)
+}
+
+// Write writes the plan to w as PlanText for people or as PlanJSON for pipelines.
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case PlanJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case PlanText, "":
+		_, err := io.WriteString(w, p.String())
+		return err
+	}
+	return fmt.Errorf("unknown plan format %s", format)
+}
+
+// String returns the plan as PlanText.
+func (p *Plan) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Secret %s/%s: %s\n", p.Secret.Namespace, p.Secret.Name, p.Secret.Action)
+	if p.CSR != nil {
+		fmt.Fprintf(b, "CertificateSigningRequest %s: submit (%s", p.CSR.Name, p.CSR.APIVersion)
+		if p.CSR.SignerName != "" {
+			fmt.Fprintf(b, ", signer %s", p.CSR.SignerName)
+		}
+		b.WriteString(")\n")
+	}
+	for _, cp := range p.Configurations {
+		if cp.Action == ConfigurationCreate {
+			fmt.Fprintf(b, "%s %s: create\n", cp.Kind, cp.Name)
+			for _, e := range cp.Entries {
+				fmt.Fprintf(b, "  %s\n", e.Name)
+			}
+			continue
+		}
+		if len(cp.Entries) == 0 {
+			fmt.Fprintf(b, "%s %s: unchanged\n", cp.Kind, cp.Name)
+			continue
+		}
+		fmt.Fprintf(b, "%s %s:\n", cp.Kind, cp.Name)
+		for _, e := range cp.Entries {
+			fmt.Fprintf(b, "  %s:\n", e.Name)
+			for _, ch := range e.Changes {
+				fmt.Fprintf(b, "    %s: %q -> %q\n", ch.Field, ch.Old, ch.New)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPlanClient() *fake.Clientset {
+	placeholder := admissionregistrationv1.WebhookClientConfig{
+		Service:  &admissionregistrationv1.ServiceReference{Name: "placeholder", Namespace: "default"},
+		CABundle: []byte("\n"),
+	}
+	return fake.NewSimpleClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: v1.ObjectMeta{Name: "validating-cfg"},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "validating.cuisongliu.com", ClientConfig: placeholder}},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: v1.ObjectMeta{Name: "mutating-cfg"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "mutating.cuisongliu.com", ClientConfig: placeholder}},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Namespace: "kube-system", Name: "extension-apiserver-authentication"},
+			Data:       map[string]string{"client-ca-file": "cluster ca"},
+		},
+	)
+}
+
+func TestCertWebHook_DryRun(t *testing.T) {
+	cli := newPlanClient()
+	c := &CertWebHook{
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook: []WebHook{
+			{ValidatingName: "validating-cfg", ObjectSelect: map[string]*v1.LabelSelector{
+				"validating.cuisongliu.com": {MatchLabels: map[string]string{"app": "web"}},
+			}},
+			{MutatingName: "mutating-cfg"},
+		},
+		client: cli,
+	}
+	plan, err := c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range cli.Actions() {
+		if a.GetVerb() != "get" {
+			t.Errorf("dry run changed %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+	want := &Plan{
+		Secret: SecretPlan{Namespace: "default", Name: "webhook-cert", Action: SecretCreate},
+		Configurations: []ConfigurationPlan{
+			{Kind: "ValidatingWebhookConfiguration", Name: "validating-cfg", Entries: []EntryPlan{{
+				Name: "validating.cuisongliu.com",
+				Changes: []Change{
+					{Field: "service.name", Old: "placeholder", New: "service"},
+					{Field: "caBundle", Old: "<empty>", New: "<generated>"},
+					{Field: "objectSelector", Old: "<none>", New: "app=web"},
+				},
+			}}},
+			{Kind: "MutatingWebhookConfiguration", Name: "mutating-cfg", Entries: []EntryPlan{{
+				Name: "mutating.cuisongliu.com",
+				Changes: []Change{
+					{Field: "service.name", Old: "placeholder", New: "service"},
+					{Field: "caBundle", Old: "<empty>", New: "<generated>"},
+				},
+			}}},
+		},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got plan\n%s\nwant\n%s", plan, want)
+	}
+
+	text := plan.String()
+	for _, line := range []string{
+		"Secret default/webhook-cert: create\n",
+		"ValidatingWebhookConfiguration validating-cfg:\n  validating.cuisongliu.com:\n",
+		`    service.name: "placeholder" -> "service"`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("text plan has no %q:\n%s", line, text)
+		}
+	}
+	out := &bytes.Buffer{}
+	if err := plan.Write(out, PlanJSON); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Plan{}
+	if err := json.Unmarshal(out.Bytes(), decoded); err != nil || !reflect.DeepEqual(decoded, plan) {
+		t.Errorf("json plan does not round trip: %v\n%s", err, out)
+	}
+
+	// after Generator everything is reused
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Secret.Action != SecretReuse {
+		t.Errorf("got secret action %s", plan.Secret.Action)
+	}
+	for _, cp := range plan.Configurations {
+		if len(cp.Entries) != 0 {
+			t.Errorf("%s %s is not unchanged: %v", cp.Kind, cp.Name, cp.Entries)
+		}
+	}
+}
+
+func TestCertWebHook_DryRunCsr(t *testing.T) {
+	cli := newPlanClient()
+	cli.Resources = []*v1.APIResourceList{{GroupVersion: "certificates.k8s.io/v1"}}
+	c := &CertWebHook{
+		Namespace:   "default",
+		ServiceName: "placeholder",
+		SecretName:  "webhook-cert",
+		CsrName:     "webhook-csr",
+		WebHook:     []WebHook{{MutatingName: "mutating-cfg"}},
+		client:      cli,
+	}
+	if _, err := c.DryRun(); err == nil {
+		t.Error("dry run of a v1 csr without signerName succeeded")
+	}
+	c.SignerName = "cuisongliu.com/webhook"
+	if _, err := c.DryRun(); err == nil {
+		t.Error("dry run of a custom signer without SignerCABundle succeeded")
+	}
+	c.SignerCABundle = "signer ca"
+	plan, err := c.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Secret.Action != SecretCreate {
+		t.Errorf("got secret action %s", plan.Secret.Action)
+	}
+	wantCSR := &CSRPlan{Name: "webhook-csr", APIVersion: "certificates.k8s.io/v1", SignerName: "cuisongliu.com/webhook"}
+	if !reflect.DeepEqual(plan.CSR, wantCSR) {
+		t.Errorf("got csr %v, want %v", plan.CSR, wantCSR)
+	}
+	wantChanges := []Change{{Field: "caBundle", Old: "<empty>", New: fingerprint([]byte("signer ca"))}}
+	if len(plan.Configurations) != 1 || len(plan.Configurations[0].Entries) != 1 ||
+		!reflect.DeepEqual(plan.Configurations[0].Entries[0].Changes, wantChanges) {
+		t.Errorf("got configurations %v", plan.Configurations)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"path"
+	"sync"
+)
+
+// servingCertificate is the certificate of CertDir served by GetCertificate.
+type servingCertificate struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// WatchSecret keeps CertDir and GetCertificate in sync with the Secret until ctx is done,
+// so certificates renewed by Rotate or by another replica are served without restarting.
+func (c *CertWebHook) WatchSecret(ctx context.Context) error {
+	factory := c.secretInformerFactory()
+	informer := factory.Core().V1().Secrets().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onSecret,
+		UpdateFunc: func(_, obj interface{}) {
+			c.onSecret(obj)
+		},
+	})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	<-ctx.Done()
+	return nil
+}
+
+// secretInformerFactory returns an informer factory that lists and watches the Secret only.
+func (c *CertWebHook) secretInformerFactory() informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(c.client, 0,
+		informers.WithNamespace(c.Namespace),
+		informers.WithTweakListOptions(func(options *v1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", c.SecretName).String()
+		}))
+}
+
+func (c *CertWebHook) onSecret(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok || !c.isSecret(secret) || !c.secretComplete(secret) {
+		return
+	}
+	if err := c.syncTLSFiles(secret); err != nil {
+		certlog.Error(err, "sync certificate files failed", "secret", c.SecretName, "namespace", c.Namespace)
+	}
+}
+
+// GetCertificate returns the certificate last written into CertDir, it is meant for tls.Config.GetCertificate
+// so servers pick up renewed certificates without restarting.
+func (c *CertWebHook) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.serving.mu.RLock()
+	cert := c.serving.cert
+	c.serving.mu.RUnlock()
+	if cert != nil {
+		return cert, nil
+	}
+	// nothing written by this process yet, serve the files of an earlier run
+	loaded, err := tls.LoadX509KeyPair(path.Join(c.CertDir, "tls.crt"), path.Join(c.CertDir, "tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	c.serving.mu.Lock()
+	defer c.serving.mu.Unlock()
+	if c.serving.cert == nil {
+		c.serving.cert = &loaded
+	}
+	return c.serving.cert, nil
+}
+
+func (c *CertWebHook) storeCertificate(certData, keyData []byte) error {
+	cert, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return err
+	}
+	c.serving.mu.Lock()
+	c.serving.cert = &cert
+	c.serving.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCertWebHook_WatchSecret(t *testing.T) {
+	c := &CertWebHook{
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+	}
+	newSecretData := func() map[string][]byte {
+		caCert, caKey, cert, key, err := c.generateSelfSignedTLS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string][]byte{caBundleKey: caCert, caKeyKey: caKey, certKey: cert, keyKey: key}
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "webhook-cert"},
+		Data:       newSecretData(),
+	}
+	cli := fake.NewSimpleClientset(secret)
+	c.client = cli
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = c.WatchSecret(ctx)
+	}()
+
+	waitServing := func(t *testing.T, want []byte) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			crt, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+			if cert, err := c.GetCertificate(nil); err == nil && bytes.Equal(crt, want) {
+				leaf, _ := ParseCertificate(want)
+				if bytes.Equal(cert.Certificate[0], leaf.Raw) {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("certificate of the secret is not served")
+	}
+	waitServing(t, secret.Data[certKey])
+
+	// renewed elsewhere
+	secret.Data = newSecretData()
+	if _, err := cli.CoreV1().Secrets("default").Update(context.TODO(), secret, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitServing(t, secret.Data[certKey])
+
+	// one directory holds the pair, nothing else is left behind
+	files, _ := ioutil.ReadDir(c.CertDir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if len(names) != 4 || names[0] != tlsDataDir || !strings.HasPrefix(names[1], "..tls-") || names[2] != "tls.crt" || names[3] != "tls.key" {
+		t.Errorf("got files %v, want tls.crt and tls.key linked into one directory", names)
+	}
+}
+
+func TestCertWebHook_GetCertificateFromFiles(t *testing.T) {
+	c := &CertWebHook{CertDir: t.TempDir(), Namespace: "default", ServiceName: "service"}
+	if _, err := c.GetCertificate(nil); err == nil {
+		t.Error("got a certificate from an empty CertDir")
+	}
+	_, _, cert, key, err := c.generateSelfSignedTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(path.Join(c.CertDir, "tls.crt"), cert, 0600)
+	_ = ioutil.WriteFile(path.Join(c.CertDir, "tls.key"), key, 0600)
+	if _, err := c.GetCertificate(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCertWebHook_WriteTLSFiles(t *testing.T) {
+	c := &CertWebHook{CertDir: t.TempDir(), Namespace: "default", ServiceName: "service"}
+	pairs := make([][2][]byte, 2)
+	for i := range pairs {
+		_, _, cert, key, err := c.generateSelfSignedTLS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs[i] = [2][]byte{cert, key}
+	}
+	// files written by earlier versions
+	_ = ioutil.WriteFile(path.Join(c.CertDir, "tls.crt"), pairs[0][0], 0600)
+	_ = ioutil.WriteFile(path.Join(c.CertDir, "tls.key"), pairs[0][1], 0600)
+	previous := ""
+	for i := 0; i < 2; i++ {
+		if err := c.writeTLSFiles(pairs[1][0], pairs[1][1]); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"tls.crt", "tls.key"} {
+			target, err := os.Readlink(path.Join(c.CertDir, name))
+			if err != nil || target != path.Join(tlsDataDir, name) {
+				t.Errorf("%s links to %q: %v", name, target, err)
+			}
+		}
+		crt, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+		key, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.key"))
+		if !bytes.Equal(crt, pairs[1][0]) || !bytes.Equal(key, pairs[1][1]) {
+			t.Error("tls.crt and tls.key are not the written pair")
+		}
+		dataDir, _ := os.Readlink(path.Join(c.CertDir, tlsDataDir))
+		if previous != "" {
+			if dataDir == previous {
+				t.Error("the pair was not written into a new directory")
+			}
+			if _, err := os.Stat(path.Join(c.CertDir, previous)); !os.IsNotExist(err) {
+				t.Errorf("the directory of the previous pair is left: %v", err)
+			}
+		}
+		previous = dataDir
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+const (
+	// defaultRotateFraction is the fraction of the certificate lifetime after which it is renewed.
+	defaultRotateFraction = 0.8
+	// rotateCheckInterval bounds the time between two checks, so certificates renewed elsewhere are noticed.
+	rotateCheckInterval = time.Hour
+	// rotateRetryInterval is the time waited after a failed rotation.
+	rotateRetryInterval = time.Minute
+	// caTrustDelay is the time between adding a renewed CA to the caBundle and serving a certificate it signed,
+	// so the apiservers trust the new CA first.
+	caTrustDelay = time.Minute
+)
+
+var certlog = logf.Log.WithName("webhook-cert")
+
+// Rotate renews the serving certificate of the Secret when RotateFraction of its lifetime has passed,
+// then repatches the caBundle of every WebHook and rewrites the files in CertDir. It runs until ctx is done.
+//
+// In SelfSigned mode the CA is renewed the same way in two passes: the new CA is added to the caBundle first
+// while the certificate of the old CA is still served, and caTrustDelay later a certificate of the new CA is
+// served. The old CA stays in the caBundle until it expires, so certificates already served keep being trusted. With LeaderElection only the replica
+// holding the lease renews, the others rewrite CertDir from the renewed Secret.
+func (c *CertWebHook) Rotate(ctx context.Context) error {
+	if err := c.validateWebHooks(); err != nil {
+		return err
+	}
+	for {
+		wait := rotateRetryInterval
+		next, err := c.rotateLeading(ctx)
+		if err != nil {
+			certlog.Error(err, "rotate certificate failed", "secret", c.SecretName, "namespace", c.Namespace)
+		} else {
+			wait = time.Until(next)
+			if wait > rotateCheckInterval {
+				wait = rotateCheckInterval
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// rotateLeading rotates the certificate, with LeaderElection the certificate is only renewed while holding the
+// lease, the other replicas wait for the renewed Secret.
+func (c *CertWebHook) rotateLeading(ctx context.Context) (time.Time, error) {
+	if !c.LeaderElection {
+		return c.rotate(time.Now())
+	}
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(ctx, c.SecretName, v1.GetOptions{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if c.needsRenewal(secret, time.Now()) {
+		err = c.runLeading(ctx, func() error {
+			_, err := c.rotate(time.Now())
+			return err
+		}, c.followSecret)
+		if err != nil {
+			return time.Time{}, err
+		}
+		secret, err = c.client.CoreV1().Secrets(c.Namespace).Get(ctx, c.SecretName, v1.GetOptions{})
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	// the certificate may have been renewed by another replica
+	if err := c.syncTLSFiles(secret); err != nil {
+		return time.Time{}, err
+	}
+	return c.renewAt(secret)
+}
+
+// rotate renews the certificate of the Secret if it is due at now and returns when the next renewal is due.
+func (c *CertWebHook) rotate(now time.Time) (time.Time, error) {
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if c.needsRenewal(secret, now) {
+		if c.SelfSigned {
+			secret, err = c.renewSelfSignedSecret(secret, now)
+		} else {
+			secret, err = c.renewCsrSecret(secret)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := c.patchWebHook(string(secret.Data[caBundleKey])); err != nil {
+			return time.Time{}, err
+		}
+		if err := c.writeTLSFiles(secret.Data[certKey], secret.Data[keyKey]); err != nil {
+			return time.Time{}, err
+		}
+		certlog.Info("certificate rotated", "secret", c.SecretName, "namespace", c.Namespace)
+	}
+	return c.renewAt(secret)
+}
+
+// renewCsrSecret gets a new key signed through the certificates API.
+func (c *CertWebHook) renewCsrSecret(secret *corev1.Secret) (*corev1.Secret, error) {
+	csr, key, err := c.generateTLS()
+	if err != nil {
+		return nil, err
+	}
+	secret.Data[csrKey] = csr
+	secret.Data[keyKey] = key
+	delete(secret.Data, certKey)
+	return c.signSecret(secret)
+}
+
+// renewSelfSignedSecret signs a new serving certificate with the CA of the secret. When the CA is due at now,
+// it only adds a new CA to the caBundle and keeps the serving certificate, which the next pass replaces
+// with one signed by the new CA once the webhooks trust it, see renewAt.
+func (c *CertWebHook) renewSelfSignedSecret(secret *corev1.Secret, now time.Time) (*corev1.Secret, error) {
+	caBundle, caKey := secret.Data[caBundleKey], secret.Data[caKeyKey]
+	ca, caErr := ParseCertificate(caBundle)
+	if caErr != nil || !now.Before(c.renewTime(ca)) {
+		newCA, newKey, err := NewSelfSignedCA(c.caConfig())
+		if err != nil {
+			return nil, err
+		}
+		// the new CA signs, the old ones are trusted until they expire
+		caBundle = append(newCA, unexpiredCertificates(caBundle, now)...)
+		caKey = newKey
+		if caErr == nil {
+			certlog.Info("CA renewed, the certificate is renewed once it is trusted", "secret", c.SecretName, "namespace", c.Namespace)
+			secret.Data[caBundleKey], secret.Data[caKeyKey] = caBundle, caKey
+			return c.client.CoreV1().Secrets(c.Namespace).Update(context.TODO(), secret, v1.UpdateOptions{})
+		}
+	}
+	cert, key, err := NewSignedCert(c.certConfig(), caBundle, caKey)
+	if err != nil {
+		return nil, err
+	}
+	secret.Data = map[string][]byte{
+		caBundleKey: caBundle,
+		caKeyKey:    caKey,
+		certKey:     cert,
+		keyKey:      key,
+	}
+	return c.client.CoreV1().Secrets(c.Namespace).Update(context.TODO(), secret, v1.UpdateOptions{})
+}
+
+// needsRenewal tells whether the serving certificate of secret, or the CA signing it in SelfSigned mode, is due at now.
+func (c *CertWebHook) needsRenewal(secret *corev1.Secret, now time.Time) bool {
+	renewAt, err := c.renewAt(secret)
+	return err != nil || !now.Before(renewAt)
+}
+
+// renewAt returns when the serving certificate of secret, or the CA signing it in SelfSigned mode, is due.
+// A serving certificate not signed by the newest CA is due caTrustDelay after that CA was created.
+func (c *CertWebHook) renewAt(secret *corev1.Secret) (time.Time, error) {
+	cert, err := ParseCertificate(secret.Data[certKey])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse certificate of secret [%s] failed %s", c.SecretName, err)
+	}
+	renewAt := c.renewTime(cert)
+	if c.SelfSigned {
+		ca, err := ParseCertificate(secret.Data[caBundleKey])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse ca of secret [%s] failed %s", c.SecretName, err)
+		}
+		if caRenewAt := c.renewTime(ca); caRenewAt.Before(renewAt) {
+			renewAt = caRenewAt
+		}
+		if trustedAt := ca.NotBefore.Add(caTrustDelay); cert.CheckSignatureFrom(ca) != nil && trustedAt.Before(renewAt) {
+			renewAt = trustedAt
+		}
+	}
+	return renewAt, nil
+}
+
+// renewTime returns the time RotateFraction of the lifetime of cert has passed.
+func (c *CertWebHook) renewTime(cert *x509.Certificate) time.Time {
+	fraction := c.RotateFraction
+	if fraction <= 0 || fraction >= 1 {
+		fraction = defaultRotateFraction
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
+
+// unexpiredCertificates returns the PEM certificates of bundle still valid at now.
+func unexpiredCertificates(bundle []byte, now time.Time) []byte {
+	var out []byte
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return out
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || !now.Before(cert.NotAfter) {
+			continue
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCertWebHook_Rotate(t *testing.T) {
+	cli := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: "mutating-cfg"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "mutating.cuisongliu.com",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{}},
+		}},
+	})
+	c := &CertWebHook{
+		CertDir:     t.TempDir(),
+		SelfSigned:  true,
+		Namespace:   "default",
+		ServiceName: "service",
+		SecretName:  "webhook-cert",
+		WebHook:     []WebHook{{MutatingName: "mutating-cfg"}},
+		client:      cli,
+	}
+	if err := c.Generator(); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+
+	// not due yet
+	next, err := c.rotate(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Add(time.Duration(float64(certValidity) * defaultRotateFraction)); next.Sub(want) > time.Minute || want.Sub(next) > time.Minute {
+		t.Errorf("got next renewal %s, want %s", next, want)
+	}
+	unchanged, _ := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if !bytes.Equal(unchanged.Data[certKey], old.Data[certKey]) {
+		t.Error("certificate renewed before it was due")
+	}
+
+	// due with its CA, the new CA is trusted before a certificate it signed is served
+	future := time.Now().Add(9 * 365 * 24 * time.Hour)
+	next, err = c.rotate(future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, _ := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if bytes.Equal(trusted.Data[caKeyKey], old.Data[caKeyKey]) {
+		t.Fatal("CA was not renewed")
+	}
+	if !bytes.Equal(trusted.Data[certKey], old.Data[certKey]) || !bytes.Equal(trusted.Data[keyKey], old.Data[keyKey]) {
+		t.Error("certificate was renewed before its CA was trusted")
+	}
+	if !bytes.HasSuffix(trusted.Data[caBundleKey], old.Data[caBundleKey]) || bytes.Equal(trusted.Data[caBundleKey], old.Data[caBundleKey]) {
+		t.Error("caBundle does not hold the new CA and the old one")
+	}
+	mwebhook, _ := cli.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "mutating-cfg", v1.GetOptions{})
+	if !bytes.Equal(mwebhook.Webhooks[0].ClientConfig.CABundle, trusted.Data[caBundleKey]) {
+		t.Error("caBundle with the new CA was not patched")
+	}
+	if crt, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt")); !bytes.Equal(crt, old.Data[certKey]) {
+		t.Error("tls.crt was rewritten before the new CA was trusted")
+	}
+	if want := time.Now().Add(caTrustDelay); next.Sub(want) > time.Minute || want.Sub(next) > time.Minute {
+		t.Errorf("got next renewal %s, want %s", next, want)
+	}
+
+	// once the new CA is trusted the certificate is signed by it
+	if _, err := c.rotate(time.Now().Add(2 * caTrustDelay)); err != nil {
+		t.Fatal(err)
+	}
+	renewed, _ := cli.CoreV1().Secrets("default").Get(context.TODO(), "webhook-cert", v1.GetOptions{})
+	if bytes.Equal(renewed.Data[certKey], old.Data[certKey]) || bytes.Equal(renewed.Data[caKeyKey], old.Data[caKeyKey]) {
+		t.Fatal("certificate and CA were not renewed")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(renewed.Data[caBundleKey]) {
+		t.Fatal("caBundle is not PEM encoded")
+	}
+	for name, pemCert := range map[string][]byte{"old": old.Data[certKey], "new": renewed.Data[certKey]} {
+		cert, err := ParseCertificate(pemCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: "service.default.svc", Roots: pool}); err != nil {
+			t.Errorf("%s certificate is not trusted by the caBundle: %v", name, err)
+		}
+	}
+	newCA, _ := ParseCertificate(renewed.Data[caBundleKey])
+	if cert, _ := ParseCertificate(renewed.Data[certKey]); cert == nil || cert.CheckSignatureFrom(newCA) != nil {
+		t.Error("certificate is not signed by the new CA")
+	}
+	mwebhook, _ = cli.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "mutating-cfg", v1.GetOptions{})
+	if !bytes.Equal(mwebhook.Webhooks[0].ClientConfig.CABundle, renewed.Data[caBundleKey]) {
+		t.Error("caBundle was not repatched")
+	}
+	crt, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+	if !bytes.Equal(crt, renewed.Data[certKey]) {
+		t.Error("tls.crt was not rewritten")
+	}
+}
+
+func TestUnexpiredCertificates(t *testing.T) {
+	a, _, _ := NewSelfSignedCA(CertConfig{CommonName: "a"})
+	b, _, _ := NewSelfSignedCA(CertConfig{CommonName: "b"})
+	bundle := append(append([]byte{}, a...), b...)
+	if got := unexpiredCertificates(bundle, time.Now()); !bytes.Equal(got, bundle) {
+		t.Errorf("got %s, want both certificates", got)
+	}
+	if got := unexpiredCertificates(bundle, time.Now().Add(caValidity+time.Hour)); len(got) != 0 {
+		t.Errorf("got %s, want no certificate", got)
+	}
+}
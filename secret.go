@@ -16,11 +16,19 @@ limitations under the License.
 package webhook
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
 	"k8s.io/api/certificates/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"os"
 	"path"
@@ -32,10 +40,14 @@ const (
 	keyKey      = "tls.key"
 	csrKey      = "tls.csr"
 	caBundleKey = "caBundle"
+	caKeyKey    = "ca.key"
 )
 
 func (c *CertWebHook) generateSecret() (*corev1.Secret, error) {
-	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(c.SecretName, v1.GetOptions{})
+	if c.SelfSigned {
+		return c.generateSelfSignedSecret()
+	}
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			return nil, err
@@ -55,51 +67,205 @@ func (c *CertWebHook) generateSecret() (*corev1.Secret, error) {
 				keyKey: key,
 			},
 		}
-		secret, err = c.client.CoreV1().Secrets(c.Namespace).Create(secret)
+		secret, err = c.client.CoreV1().Secrets(c.Namespace).Create(context.TODO(), secret, v1.CreateOptions{})
 		if err != nil {
 			return nil, err
 		}
 	}
+	return c.signSecret(secret)
+}
+
+// signSecret gets the CSR of secret signed and stores the certificate with the cluster CA in secret.
+func (c *CertWebHook) signSecret(secret *corev1.Secret) (*corev1.Secret, error) {
+	//ca
+	caData, err := c.signerCABundle()
+	if err != nil {
+		return nil, err
+	}
 	//csr
 	err = c.pathCsr(secret)
 	if err != nil {
 		return nil, err
 	}
-	//ca
-	caConfigMap, err := c.client.CoreV1().ConfigMaps("kube-system").Get("extension-apiserver-authentication", v1.GetOptions{})
+	secret.Data[caBundleKey] = []byte(caData)
+	secret, err = c.client.CoreV1().Secrets(c.Namespace).Update(context.TODO(), secret, v1.UpdateOptions{})
 	if err != nil {
 		return nil, err
 	}
+	return secret, nil
+}
+
+// signerCABundle returns the CA of the signer of the CSRs: SignerCABundle, or the cluster CA
+// for the legacy and kubelet-serving signers.
+func (c *CertWebHook) signerCABundle() (string, error) {
+	if c.SignerCABundle != "" {
+		return c.SignerCABundle, nil
+	}
+	switch c.SignerName {
+	case "", v1beta1.LegacyUnknownSignerName, certificatesv1.KubeletServingSignerName:
+		return c.clusterCABundle()
+	}
+	return "", errors.NewBadRequest(fmt.Sprintf("SignerCABundle is required by the signer %s, the cluster CA does not verify its certificates", c.SignerName))
+}
+
+// clusterCABundle returns the cluster CA signing the certificates of the legacy and kubelet-serving signers.
+func (c *CertWebHook) clusterCABundle() (string, error) {
+	caConfigMap, err := c.client.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "extension-apiserver-authentication", v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
 	var caData string
 	if caConfigMap != nil {
 		caData = caConfigMap.Data["client-ca-file"]
 	} else {
-		return nil, errors.NewUnauthorized("ca configmap [extension-apiserver-authentication] data [client-ca-file] is not found.")
+		return "", errors.NewUnauthorized("ca configmap [extension-apiserver-authentication] data [client-ca-file] is not found.")
 	}
-	secret.Data[caBundleKey] = []byte(caData)
-	secret, err = c.client.CoreV1().Secrets(c.Namespace).Update(secret)
-	if err != nil {
+	return caData, nil
+}
+
+// generateSelfSignedSecret reuses the CA and serving certificate of the Secret, or creates them.
+func (c *CertWebHook) generateSelfSignedSecret() (*corev1.Secret, error) {
+	secret, err := c.client.CoreV1().Secrets(c.Namespace).Get(context.TODO(), c.SecretName, v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
 		return nil, err
 	}
-	return secret, nil
+	if err == nil && len(secret.Data[caKeyKey]) != 0 && len(secret.Data[certKey]) != 0 {
+		now := time.Now()
+		if !c.needsRenewal(secret, now) {
+			return secret, nil
+		}
+		return c.renewSelfSignedSecret(secret, now)
+	}
+	caCert, caKey, cert, key, genErr := c.generateSelfSignedTLS()
+	if genErr != nil {
+		return nil, genErr
+	}
+	data := map[string][]byte{
+		caBundleKey: caCert,
+		caKeyKey:    caKey,
+		certKey:     cert,
+		keyKey:      key,
+	}
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Namespace: c.Namespace,
+				Name:      c.SecretName,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		return c.client.CoreV1().Secrets(c.Namespace).Create(context.TODO(), secret, v1.CreateOptions{})
+	}
+	secret.Data = data
+	return c.client.CoreV1().Secrets(c.Namespace).Update(context.TODO(), secret, v1.UpdateOptions{})
 }
+
+// pathCsr gets the CSR of secret approved and signed, through certificates.k8s.io/v1 when the cluster serves it
+// and v1beta1 otherwise, and stores the certificate in secret.
 func (c *CertWebHook) pathCsr(secret *corev1.Secret) error {
+	v1Available, err := c.csrV1Available()
+	if err != nil {
+		return err
+	}
+	if v1Available {
+		if err := c.checkSignerName(); err != nil {
+			return err
+		}
+		return c.pathCsrV1(secret)
+	}
+	return c.pathCsrV1beta1(secret)
+}
+
+// csrV1Available tells whether the cluster serves certificates.k8s.io/v1.
+func (c *CertWebHook) csrV1Available() (bool, error) {
+	groups, err := c.client.Discovery().ServerGroups()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range groups.Groups {
+		if g.Name != certificatesv1.GroupName {
+			continue
+		}
+		for _, v := range g.Versions {
+			if v.Version == certificatesv1.SchemeGroupVersion.Version {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (c *CertWebHook) pathCsrV1(secret *corev1.Secret) error {
 	dPolicy := v1.DeletePropagationBackground
 	label := map[string]string{
 		"csr-name": c.CsrName,
 	}
-	_ = c.client.CertificatesV1beta1().CertificateSigningRequests().Delete(c.CsrName, &v1.DeleteOptions{PropagationPolicy: &dPolicy})
+	_ = c.client.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), c.CsrName, v1.DeleteOptions{PropagationPolicy: &dPolicy})
+	csrResource := &certificatesv1.CertificateSigningRequest{}
+	csrResource.Name = c.CsrName
+	csrResource.Labels = label
+	csrResource.Spec.SignerName = c.SignerName
+	csrResource.Spec.ExpirationSeconds = c.expirationSeconds()
+	csrResource.Spec.Usages = []certificatesv1.KeyUsage{
+		certificatesv1.UsageDigitalSignature,
+		certificatesv1.UsageKeyEncipherment,
+		certificatesv1.UsageServerAuth,
+	}
+	csrResource.Spec.Request = secret.Data[csrKey]
+	csrResource, err := c.client.CertificatesV1().CertificateSigningRequests().Create(context.TODO(), csrResource, v1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	csrResource.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{
+		{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue, Reason: "PodSelfApprove", Message: "This CSR was approved by pod certificate approve.", LastUpdateTime: v1.NewTime(time.Now())},
+	}
+	csrResource, err = c.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(context.TODO(), c.CsrName, csrResource, v1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	if csrResource.Status.Certificate != nil {
+		secret.Data[certKey] = csrResource.Status.Certificate
+		return nil
+	}
+	w, err := c.client.CertificatesV1().CertificateSigningRequests().Watch(context.TODO(), v1.ListOptions{LabelSelector: "csr-name=" + c.CsrName})
+	if err != nil {
+		return err
+	}
+	cert, err := waitCsrCertificate(w, func(obj runtime.Object) []byte {
+		if csr, ok := obj.(*certificatesv1.CertificateSigningRequest); ok {
+			return csr.Status.Certificate
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	secret.Data[certKey] = cert
+	return nil
+}
+
+func (c *CertWebHook) pathCsrV1beta1(secret *corev1.Secret) error {
+	dPolicy := v1.DeletePropagationBackground
+	label := map[string]string{
+		"csr-name": c.CsrName,
+	}
+	_ = c.client.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), c.CsrName, v1.DeleteOptions{PropagationPolicy: &dPolicy})
 	csrResource := &v1beta1.CertificateSigningRequest{}
 	csrResource.Name = c.CsrName
 	csrResource.Labels = label
 	csrResource.Spec.Groups = []string{"system:authenticated"}
+	if c.SignerName != "" {
+		csrResource.Spec.SignerName = &c.SignerName
+	}
+	csrResource.Spec.ExpirationSeconds = c.expirationSeconds()
 	csrResource.Spec.Usages = []v1beta1.KeyUsage{
 		"digital signature",
 		"key encipherment",
 		"server auth",
 	}
 	csrResource.Spec.Request = secret.Data[csrKey]
-	csrResource, err := c.client.CertificatesV1beta1().CertificateSigningRequests().Create(csrResource)
+	csrResource, err := c.client.CertificatesV1beta1().CertificateSigningRequests().Create(context.TODO(), csrResource, v1.CreateOptions{})
 
 	if err != nil {
 		return err
@@ -107,82 +273,127 @@ func (c *CertWebHook) pathCsr(secret *corev1.Secret) error {
 	csrResource.Status.Conditions = []v1beta1.CertificateSigningRequestCondition{
 		{Type: v1beta1.CertificateApproved, Reason: "PodSelfApprove", Message: "This CSR was approved by pod certificate approve.", LastUpdateTime: v1.NewTime(time.Now())},
 	}
-	csrResource, err = c.client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(csrResource)
+	csrResource, err = c.client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(context.TODO(), csrResource, v1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	if csrResource.Status.Certificate != nil {
+		secret.Data[certKey] = csrResource.Status.Certificate
+		return nil
+	}
+	w, err := c.client.CertificatesV1beta1().CertificateSigningRequests().Watch(context.TODO(), v1.ListOptions{LabelSelector: "csr-name=" + c.CsrName})
 	if err != nil {
 		return err
 	}
-	w, err := c.client.CertificatesV1beta1().CertificateSigningRequests().Watch(v1.ListOptions{LabelSelector: "csr-name=" + c.CsrName})
+	cert, err := waitCsrCertificate(w, func(obj runtime.Object) []byte {
+		if csr, ok := obj.(*v1beta1.CertificateSigningRequest); ok {
+			return csr.Status.Certificate
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	secret.Data[certKey] = cert
+	return nil
+}
+
+// waitCsrCertificate waits for the certificate of a watched CSR.
+func waitCsrCertificate(w watch.Interface, certificate func(runtime.Object) []byte) ([]byte, error) {
+	defer w.Stop()
+	timeout := time.After(time.Second * 10)
 	for {
 		select {
-		case <-time.After(time.Second * 10):
-			return errors.NewBadRequest("The CSR is not ready.")
-		case event := <-w.ResultChan():
+		case <-timeout:
+			return nil, errors.NewBadRequest("The CSR is not ready.")
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil, errors.NewBadRequest("The CSR watch is closed.")
+			}
 			if event.Type == watch.Modified || event.Type == watch.Added {
-				csr := event.Object.(*v1beta1.CertificateSigningRequest)
-				if csr.Status.Certificate != nil {
-					secret.Data[certKey] = csr.Status.Certificate
-					return nil
+				if cert := certificate(event.Object); cert != nil {
+					return cert, nil
 				}
 			}
 		}
 	}
 }
+
+// checkSignerName fails when a certificates.k8s.io/v1 CSR has no signerName, which v1 requires.
+func (c *CertWebHook) checkSignerName() error {
+	if c.SignerName == "" {
+		return errors.NewBadRequest("SignerName is required by certificates.k8s.io/v1 CSRs, set it to the signer issuing the webhook certificate or use SelfSigned")
+	}
+	return nil
+}
+
+func (c *CertWebHook) expirationSeconds() *int32 {
+	if c.ExpirationSeconds == 0 {
+		return nil
+	}
+	return &c.ExpirationSeconds
+}
+
 func (c *CertWebHook) patchWebHook(caBundle string) error {
 	for _, wk := range c.WebHook {
 
-		if wk.ValidatingName != "" {
-			vwebhook, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(wk.ValidatingName, v1.GetOptions{})
+		if len(wk.Validating) != 0 {
+			if err := c.applyValidating(wk, caBundle); err != nil {
+				return err
+			}
+		} else if wk.ValidatingName != "" {
+			vwebhook, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), wk.ValidatingName, v1.GetOptions{})
 			if err != nil {
 				return err
 			}
-			for i := range vwebhook.Webhooks {
-				vwebhook.Webhooks[i].ClientConfig.Service.Name = c.ServiceName
-				vwebhook.Webhooks[i].ClientConfig.Service.Namespace = c.Namespace
-
-				vwebhook.Webhooks[i].ClientConfig.CABundle = []byte(caBundle)
-				if wk.NamespaceSelect != nil {
-					if v, ok := wk.NamespaceSelect[vwebhook.Webhooks[i].Name]; ok {
-						vwebhook.Webhooks[i].NamespaceSelector = v
-					}
-				}
-				if wk.ObjectSelect != nil {
-					if v, ok := wk.ObjectSelect[vwebhook.Webhooks[i].Name]; ok {
-						vwebhook.Webhooks[i].ObjectSelector = v
-					}
+			orig := vwebhook.DeepCopy()
+			c.injectValidating(wk, vwebhook, caBundle)
+			if !equality.Semantic.DeepEqual(orig, vwebhook) {
+				_, err = c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), vwebhook, v1.UpdateOptions{})
+				if err != nil {
+					return err
 				}
 			}
-			_, err = c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(vwebhook)
+		}
+
+		if len(wk.Mutating) != 0 {
+			if err := c.applyMutating(wk, caBundle); err != nil {
+				return err
+			}
+		} else if wk.MutatingName != "" {
+			mwebhook, err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), wk.MutatingName, v1.GetOptions{})
 			if err != nil {
 				return err
 			}
+			orig := mwebhook.DeepCopy()
+			c.injectMutating(wk, mwebhook, caBundle)
+			if !equality.Semantic.DeepEqual(orig, mwebhook) {
+				_, err = c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), mwebhook, v1.UpdateOptions{})
+				if err != nil {
+					return err
+				}
+			}
 		}
 
-		if wk.MutatingName != "" {
-			mwebhook, err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(wk.MutatingName, v1.GetOptions{})
+		if wk.CRDName != "" {
+			crd, err := c.crdClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), wk.CRDName, v1.GetOptions{})
 			if err != nil {
 				return err
 			}
-			for i := range mwebhook.Webhooks {
-				mwebhook.Webhooks[i].ClientConfig.Service.Name = c.ServiceName
-				mwebhook.Webhooks[i].ClientConfig.Service.Namespace = c.Namespace
-
-				mwebhook.Webhooks[i].ClientConfig.CABundle = []byte(caBundle)
-				if wk.NamespaceSelect != nil {
-					if v, ok := wk.NamespaceSelect[mwebhook.Webhooks[i].Name]; ok {
-						mwebhook.Webhooks[i].NamespaceSelector = v
-					}
-				}
-				if wk.ObjectSelect != nil {
-					if v, ok := wk.ObjectSelect[mwebhook.Webhooks[i].Name]; ok {
-						mwebhook.Webhooks[i].ObjectSelector = v
-					}
+			orig := crd.DeepCopy()
+			if err := c.injectCRD(crd, caBundle); err != nil {
+				return err
+			}
+			if !equality.Semantic.DeepEqual(orig, crd) {
+				_, err = c.crdClient.ApiextensionsV1().CustomResourceDefinitions().Update(context.TODO(), crd, v1.UpdateOptions{})
+				if err != nil {
+					return err
 				}
 			}
-			_, err = c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(mwebhook)
-			if err != nil {
+		}
+
+		if wk.APIServiceName != "" {
+			if err := c.patchAPIService(wk.APIServiceName, caBundle); err != nil {
 				return err
 			}
 		}
@@ -190,17 +401,151 @@ func (c *CertWebHook) patchWebHook(caBundle string) error {
 	return nil
 }
 
+func (c *CertWebHook) injectValidating(wk WebHook, vwebhook *admissionregistrationv1.ValidatingWebhookConfiguration, caBundle string) {
+	for i := range vwebhook.Webhooks {
+		w := &vwebhook.Webhooks[i]
+		c.injectWebhook(wk, w.Name, &w.ClientConfig, &w.NamespaceSelector, &w.ObjectSelector, caBundle)
+	}
+}
+
+func (c *CertWebHook) injectMutating(wk WebHook, mwebhook *admissionregistrationv1.MutatingWebhookConfiguration, caBundle string) {
+	for i := range mwebhook.Webhooks {
+		w := &mwebhook.Webhooks[i]
+		c.injectWebhook(wk, w.Name, &w.ClientConfig, &w.NamespaceSelector, &w.ObjectSelector, caBundle)
+	}
+}
+
+// injectWebhook points the webhook entry name at the service with caBundle and sets the selectors of wk for it.
+func (c *CertWebHook) injectWebhook(wk WebHook, name string, clientConfig *admissionregistrationv1.WebhookClientConfig,
+	namespaceSelector, objectSelector **v1.LabelSelector, caBundle string) {
+	if clientConfig.Service == nil {
+		clientConfig.Service = &admissionregistrationv1.ServiceReference{}
+	}
+	clientConfig.Service.Name = c.ServiceName
+	clientConfig.Service.Namespace = c.Namespace
+
+	clientConfig.CABundle = []byte(caBundle)
+	if wk.NamespaceSelect != nil {
+		if v, ok := wk.NamespaceSelect[name]; ok {
+			*namespaceSelector = v
+		}
+	}
+	if wk.ObjectSelect != nil {
+		if v, ok := wk.ObjectSelect[name]; ok {
+			*objectSelector = v
+		}
+	}
+}
+
+// injectCRD points the conversion webhook of crd at the service with caBundle.
+// A conversion webhook reached by url keeps it.
+func (c *CertWebHook) injectCRD(crd *apiextensionsv1.CustomResourceDefinition, caBundle string) error {
+	if crd.Spec.Conversion == nil || crd.Spec.Conversion.Webhook == nil || crd.Spec.Conversion.Webhook.ClientConfig == nil {
+		return errors.NewBadRequest(fmt.Sprintf("crd [%s] has no conversion webhook configured.", crd.Name))
+	}
+	clientConfig := crd.Spec.Conversion.Webhook.ClientConfig
+	if clientConfig.URL == nil {
+		if clientConfig.Service == nil {
+			clientConfig.Service = &apiextensionsv1.ServiceReference{}
+		}
+		clientConfig.Service.Name = c.ServiceName
+		clientConfig.Service.Namespace = c.Namespace
+	}
+	clientConfig.CABundle = []byte(caBundle)
+	return nil
+}
+
+// tlsDataDir is the link in CertDir to the directory holding the current tls.crt and tls.key. Both files are
+// links into it, so they are switched together by renaming one link like the AtomicWriter of the kubelet does.
+const tlsDataDir = "..data"
+
+// writeTLSFiles replaces the files in CertDir atomically and reloads the certificate served by GetCertificate.
 func (c *CertWebHook) writeTLSFiles(certData []byte, keyData []byte) error {
 	if _, err := os.Stat(c.CertDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(c.CertDir, 0700); err != nil {
 			return err
 		}
 	}
-	if err := ioutil.WriteFile(path.Join(c.CertDir, "tls.crt"), certData, 0600); err != nil {
+	if err := linkTLSFiles(c.CertDir); err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(path.Join(c.CertDir, "tls.key"), keyData, 0600); err != nil {
+	if err := swapTLSFiles(c.CertDir, certData, keyData); err != nil {
 		return err
 	}
+	return c.storeCertificate(certData, keyData)
+}
+
+// linkTLSFiles makes tls.crt and tls.key of dir links into tlsDataDir. Files written by earlier versions
+// are moved into tlsDataDir first, so the pair is never mixed.
+func linkTLSFiles(dir string) error {
+	linked := true
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if fi, err := os.Lstat(path.Join(dir, name)); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			linked = false
+		}
+	}
+	if linked {
+		return nil
+	}
+	certData, certErr := ioutil.ReadFile(path.Join(dir, "tls.crt"))
+	keyData, keyErr := ioutil.ReadFile(path.Join(dir, "tls.key"))
+	if certErr == nil && keyErr == nil {
+		if err := swapTLSFiles(dir, certData, keyData); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if err := replaceSymlink(path.Join(tlsDataDir, name), path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// swapTLSFiles writes the pair into a new directory of dir, points tlsDataDir at it with one rename
+// and removes the directory of the previous pair.
+func swapTLSFiles(dir string, certData, keyData []byte) error {
+	dataDir, err := ioutil.TempDir(dir, "..tls-")
+	if err != nil {
+		return err
+	}
+	for name, data := range map[string][]byte{"tls.crt": certData, "tls.key": keyData} {
+		if err := ioutil.WriteFile(path.Join(dataDir, name), data, 0600); err != nil {
+			_ = os.RemoveAll(dataDir)
+			return err
+		}
+	}
+	previous, _ := os.Readlink(path.Join(dir, tlsDataDir))
+	if err := replaceSymlink(path.Base(dataDir), path.Join(dir, tlsDataDir)); err != nil {
+		_ = os.RemoveAll(dataDir)
+		return err
+	}
+	if previous != "" {
+		_ = os.RemoveAll(path.Join(dir, previous))
+	}
+	return nil
+}
+
+// replaceSymlink points the link name at target through a temporary link renamed to name,
+// so readers see either the old or the new target.
+func replaceSymlink(target, name string) error {
+	tmp := fmt.Sprintf("%s-%d.tmp", name, time.Now().UnixNano())
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// syncTLSFiles writes the certificate and key of secret into CertDir when the files differ.
+func (c *CertWebHook) syncTLSFiles(secret *corev1.Secret) error {
+	certData, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.crt"))
+	keyData, _ := ioutil.ReadFile(path.Join(c.CertDir, "tls.key"))
+	if bytes.Equal(certData, secret.Data[certKey]) && bytes.Equal(keyData, secret.Data[keyKey]) {
+		return nil
+	}
+	return c.writeTLSFiles(secret.Data[certKey], secret.Data[keyKey])
+}
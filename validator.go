@@ -32,11 +32,19 @@ package webhook
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
-	"k8s.io/api/admission/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -48,20 +56,48 @@ type Validator interface {
 	ValidateDelete() error
 }
 
+// ConnectValidator is implemented by a Validator which validates CONNECT operations.
+// options is the typed options object, e.g. a *corev1.PodExecOptions for pods/exec.
+type ConnectValidator interface {
+	ValidateConnect(options runtime.Object) error
+}
+
 // ValidatingWebhookFor creates a new Webhook for validating the provided type.
+// Every request works on a shallow copy of validator.
 func ValidatingWebhookFor(validator Validator) *admission.Webhook {
+	return ValidatingWebhookForFunc(prototypeFunc(validator))
+}
+
+// ValidatingWebhookForFunc creates a new Webhook for validating, calling newFunc
+// for a fresh Validator on every request.
+// Requests are decoded into the type returned by OutRuntimeObject of the Validator.
+func ValidatingWebhookForFunc(newFunc RuntimeObjectFunc) *admission.Webhook {
 	return &admission.Webhook{
-		Handler: &validatingHandler{validator: validator},
+		Handler: &validatingHandler{
+			newValidator: func() Validator {
+				return newFunc().(Validator)
+			},
+			object: newFunc().OutRuntimeObject(),
+		},
 	}
 }
 
 type validatingHandler struct {
-	validator Validator
-	decoder   *admission.Decoder
+	newValidator    func() Validator
+	object          runtime.Object
+	decoder         *admission.Decoder
+	scheme          *runtime.Scheme
+	denyUnsupported bool
 }
 
 var _ admission.DecoderInjector = &validatingHandler{}
 
+// InjectScheme injects the scheme used to find the type of untyped requests and CONNECT options into a validatingHandler.
+func (h *validatingHandler) InjectScheme(s *runtime.Scheme) error {
+	h.scheme = s
+	return nil
+}
+
 // InjectDecoder injects the decoder into a validatingHandler.
 func (h *validatingHandler) InjectDecoder(d *admission.Decoder) error {
 	h.decoder = d
@@ -70,57 +106,285 @@ func (h *validatingHandler) InjectDecoder(d *admission.Decoder) error {
 
 // Handle handles admission requests.
 func (h *validatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
-	if h.validator == nil {
+	if h.newValidator == nil {
 		panic("validator should never be nil")
 	}
+	validator := h.newValidator()
+	return withWarnings(h.handle(req, validator), warningsOf(validator))
+}
+
+func (h *validatingHandler) handle(req admission.Request, validator Validator) admission.Response {
+	if h.denyUnsupported && !supportsOperation(req.Operation, validator) {
+		return deniedUnsupported(req)
+	}
 	// Get the object in the request
-	if req.Operation == v1beta1.Create {
-		into := &unstructured.Unstructured{}
+	if req.Operation == admissionv1.Create {
+		into := newRequestObject(h.scheme, h.object, req)
 		err := h.decoder.Decode(req, into)
-		h.validator.IntoRuntimeObject(into)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
 		}
-		err = h.validator.ValidateCreate()
+		validator.IntoRuntimeObject(into)
+		err = validator.ValidateCreate()
 		if err != nil {
-			return admission.Denied(err.Error())
+			return deniedFromError(req, err)
 		}
 	}
 
-	if req.Operation == v1beta1.Update {
-		oldObj := h.validator.OutRuntimeObject().DeepCopyObject()
-		into := &unstructured.Unstructured{}
+	if req.Operation == admissionv1.Update {
+		into := newRequestObject(h.scheme, h.object, req)
 		err := h.decoder.DecodeRaw(req.Object, into)
-		h.validator.IntoRuntimeObject(into)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
 		}
-		err = h.decoder.DecodeRaw(req.OldObject, oldObj)
+		validator.IntoRuntimeObject(into)
+		oldInto := newRequestObject(h.scheme, h.object, req)
+		err = h.decoder.DecodeRaw(req.OldObject, oldInto)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
 		}
+		oldValidator := h.newValidator()
+		oldValidator.IntoRuntimeObject(oldInto)
 
-		err = h.validator.ValidateUpdate(oldObj)
+		err = validator.ValidateUpdate(oldValidator.OutRuntimeObject())
 		if err != nil {
-			return admission.Denied(err.Error())
+			return deniedFromError(req, err)
 		}
 	}
 
-	if req.Operation == v1beta1.Delete {
+	if req.Operation == admissionv1.Delete {
 		// In reference to PR: https://github.com/kubernetes/kubernetes/pull/76346
 		// OldObject contains the object being deleted
-		into := &unstructured.Unstructured{}
+		into := newRequestObject(h.scheme, h.object, req)
 		err := h.decoder.DecodeRaw(req.OldObject, into)
-		h.validator.IntoRuntimeObject(into)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		validator.IntoRuntimeObject(into)
+
+		err = validator.ValidateDelete()
+		if err != nil {
+			return deniedFromError(req, err)
+		}
+	}
+
+	if cv, ok := validator.(ConnectValidator); ok && req.Operation == admissionv1.Connect {
+		options, err := decodeConnectOptions(h.scheme, h.decoder, req)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		err = cv.ValidateConnect(options)
+		if err != nil {
+			return deniedFromError(req, err)
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// CustomValidator defines functions for validating an operation.
+// Unlike Validator it is given the objects and a context carrying the admission request,
+// see RequestFromContext.
+type CustomValidator interface {
+	ValidateCreate(ctx context.Context, obj runtime.Object) error
+	ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error
+	ValidateDelete(ctx context.Context, obj runtime.Object) error
+}
+
+// CustomConnectValidator is implemented by a CustomValidator which validates CONNECT operations.
+// options is the typed options object, e.g. a *corev1.PodExecOptions for pods/exec.
+type CustomConnectValidator interface {
+	ValidateConnect(ctx context.Context, options runtime.Object) error
+}
+
+// CustomValidatingWebhookFor creates a new Webhook for validating objects of the type of obj.
+func CustomValidatingWebhookFor(obj runtime.Object, validator CustomValidator) *admission.Webhook {
+	return &admission.Webhook{
+		Handler: &customValidatingHandler{object: obj, validator: validator},
+	}
+}
+
+type customValidatingHandler struct {
+	object          runtime.Object
+	validator       CustomValidator
+	decoder         *admission.Decoder
+	scheme          *runtime.Scheme
+	denyUnsupported bool
+}
+
+var _ admission.DecoderInjector = &customValidatingHandler{}
+
+// InjectScheme injects the scheme used to find the type of untyped requests and CONNECT options into a customValidatingHandler.
+func (h *customValidatingHandler) InjectScheme(s *runtime.Scheme) error {
+	h.scheme = s
+	return nil
+}
+
+// InjectDecoder injects the decoder into a customValidatingHandler.
+func (h *customValidatingHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+// Handle handles admission requests.
+func (h *customValidatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if h.validator == nil {
+		panic("validator should never be nil")
+	}
+	ctx, warnings := newContextWithWarnings(NewContextWithRequest(ctx, req))
+	return withWarnings(h.handle(ctx, req), warnings.get())
+}
+
+func (h *customValidatingHandler) handle(ctx context.Context, req admission.Request) admission.Response {
+	if h.denyUnsupported && !supportsOperation(req.Operation, h.validator) {
+		return deniedUnsupported(req)
+	}
+	// Get the object in the request
+	if req.Operation == admissionv1.Create {
+		obj := newRequestObject(h.scheme, h.object, req)
+		err := h.decoder.Decode(req, obj)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = h.validator.ValidateCreate(ctx, obj)
+		if err != nil {
+			return deniedFromError(req, err)
+		}
+	}
+
+	if req.Operation == admissionv1.Update {
+		obj := newRequestObject(h.scheme, h.object, req)
+		err := h.decoder.DecodeRaw(req.Object, obj)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		oldObj := newRequestObject(h.scheme, h.object, req)
+		err = h.decoder.DecodeRaw(req.OldObject, oldObj)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		err = h.validator.ValidateUpdate(ctx, oldObj, obj)
+		if err != nil {
+			return deniedFromError(req, err)
+		}
+	}
+
+	if req.Operation == admissionv1.Delete {
+		// OldObject contains the object being deleted
+		obj := newRequestObject(h.scheme, h.object, req)
+		err := h.decoder.DecodeRaw(req.OldObject, obj)
 		if err != nil {
 			return admission.Errored(http.StatusBadRequest, err)
 		}
 
-		err = h.validator.ValidateDelete()
+		err = h.validator.ValidateDelete(ctx, obj)
 		if err != nil {
-			return admission.Denied(err.Error())
+			return deniedFromError(req, err)
+		}
+	}
+
+	if cv, ok := h.validator.(CustomConnectValidator); ok && req.Operation == admissionv1.Connect {
+		options, err := decodeConnectOptions(h.scheme, h.decoder, req)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		err = cv.ValidateConnect(ctx, options)
+		if err != nil {
+			return deniedFromError(req, err)
 		}
 	}
 
 	return admission.Allowed("")
 }
+
+// supportsOperation reports whether validator handles op.
+func supportsOperation(op admissionv1.Operation, validator interface{}) bool {
+	switch op {
+	case admissionv1.Create, admissionv1.Update, admissionv1.Delete:
+		return true
+	case admissionv1.Connect:
+		if s, ok := validator.(connectSupporter); ok {
+			return s.supportsConnect()
+		}
+		_, ok := validator.(ConnectValidator)
+		_, customOk := validator.(CustomConnectValidator)
+		return ok || customOk
+	}
+	return false
+}
+
+// connectSupporter is implemented by validators wrapping others, they handle CONNECT only when a wrapped one does.
+type connectSupporter interface {
+	supportsConnect() bool
+}
+
+func deniedUnsupported(req admission.Request) admission.Response {
+	return admission.Denied(fmt.Sprintf("operation %s on %s is not supported", req.Operation, req.Resource.Resource))
+}
+
+// decodeConnectOptions decodes the options of a CONNECT request into the type registered
+// for its kind in scheme, e.g. a *corev1.PodExecOptions for pods/exec.
+// Kinds unknown to the scheme are decoded as unstructured.
+func decodeConnectOptions(scheme *runtime.Scheme, decoder *admission.Decoder, req admission.Request) (runtime.Object, error) {
+	if len(req.Object.Raw) == 0 {
+		return nil, errors.New("there is no content to decode")
+	}
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(req.Object.Raw, &typeMeta); err != nil {
+		return nil, err
+	}
+	gvk := typeMeta.GroupVersionKind()
+	if gvk.Empty() {
+		gvk = schema.GroupVersionKind(req.Kind)
+	}
+	options, err := schemeOrDefault(scheme).New(gvk)
+	if err != nil {
+		options = &unstructured.Unstructured{}
+	}
+	return options, decoder.DecodeRaw(req.Object, options)
+}
+
+// deniedFromError returns a denied response for err. The code, reason and causes
+// of an apierrors.APIStatus are kept, and a field.ErrorList (or its aggregate)
+// is turned into an Invalid status for the kind and name of the request.
+func deniedFromError(req admission.Request, err error) admission.Response {
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &status,
+			},
+		}
+	}
+	if list := fieldErrors(err); len(list) != 0 {
+		gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+		return deniedFromError(req, apierrors.NewInvalid(gk, req.Name, list))
+	}
+	return admission.Denied(err.Error())
+}
+
+// fieldErrors returns err as a field.ErrorList, or nil if it holds other errors.
+func fieldErrors(err error) field.ErrorList {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		var list field.ErrorList
+		for _, e := range agg.Errors() {
+			fe, ok := e.(*field.Error)
+			if !ok {
+				return nil
+			}
+			list = append(list, fe)
+		}
+		return list
+	}
+	var fe *field.Error
+	if errors.As(err, &fe) {
+		return field.ErrorList{fe}
+	}
+	return nil
+}
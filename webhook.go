@@ -16,12 +16,24 @@ limitations under the License.
 package webhook
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net/http"
+	"net/url"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"sync"
 )
 
 // Defaulter defines functions for setting defaults on resources
@@ -40,22 +52,411 @@ func JsonConvert(from interface{}, to interface{}) error {
 	return errors.WithStack(json.Unmarshal(data, to))
 }
 
+type requestContextKey struct{}
+
+// NewContextWithRequest returns a copy of ctx carrying the admission request.
+func NewContextWithRequest(ctx context.Context, req admission.Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, req)
+}
+
+// RequestFromContext returns the admission request the handlers stored in ctx,
+// giving CustomDefaulter and CustomValidator access to the user, dry-run flag and namespace.
+func RequestFromContext(ctx context.Context) (admission.Request, error) {
+	if req, ok := ctx.Value(requestContextKey{}).(admission.Request); ok {
+		return req, nil
+	}
+	return admission.Request{}, errors.New("admission.Request not found in context")
+}
+
+// Warner is implemented by a Defaulter or Validator having warnings for the request it handled.
+// The warnings are returned to the client without rejecting the request.
+type Warner interface {
+	Warnings() []string
+}
+
+func warningsOf(i interface{}) []string {
+	if w, ok := i.(Warner); ok {
+		return w.Warnings()
+	}
+	return nil
+}
+
+type warningsContextKey struct{}
+
+type warningList struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (w *warningList) get() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.warnings
+}
+
+func newContextWithWarnings(ctx context.Context) (context.Context, *warningList) {
+	w := &warningList{}
+	return context.WithValue(ctx, warningsContextKey{}, w), w
+}
+
+// AddWarning records a warning for the admission request of ctx, which must be the context
+// given to a CustomDefaulter or CustomValidator. The warning is returned to the client
+// without rejecting the request.
+func AddWarning(ctx context.Context, warning string) {
+	if w, ok := ctx.Value(warningsContextKey{}).(*warningList); ok {
+		w.mu.Lock()
+		w.warnings = append(w.warnings, warning)
+		w.mu.Unlock()
+	}
+}
+
+// withWarnings appends warnings to the warnings of resp.
+func withWarnings(resp admission.Response, warnings []string) admission.Response {
+	resp.Warnings = append(resp.Warnings, warnings...)
+	return resp
+}
+
+// newRequestObject returns an empty object to decode the objects of req into. It has the type
+// of obj, or of the kind of req registered in scheme when obj is nil, and is unstructured otherwise.
+func newRequestObject(scheme *runtime.Scheme, obj runtime.Object, req admission.Request) runtime.Object {
+	if obj == nil {
+		typed, err := schemeOrDefault(scheme).New(schema.GroupVersionKind(req.Kind))
+		if err != nil {
+			return &unstructured.Unstructured{}
+		}
+		return typed
+	}
+	if t := reflect.TypeOf(obj); t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(runtime.Object)
+	}
+	return obj.DeepCopyObject()
+}
+
+// schemeOrDefault returns scheme, or the client-go scheme with all built-in types if it is nil.
+func schemeOrDefault(scheme *runtime.Scheme) *runtime.Scheme {
+	if scheme == nil {
+		return clientgoscheme.Scheme
+	}
+	return scheme
+}
+
+// RuntimeObjectFunc returns a new RuntimeObject. The handlers call it once per
+// admission request so concurrent requests never share the decoded object.
+type RuntimeObjectFunc func() RuntimeObject
+
+// prototypeFunc returns a RuntimeObjectFunc making a shallow copy of prototype,
+// so fields such as the injected client are kept while the object is isolated.
+func prototypeFunc(prototype RuntimeObject) RuntimeObjectFunc {
+	t := reflect.TypeOf(prototype)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return func() RuntimeObject {
+			return prototype
+		}
+	}
+	return func() RuntimeObject {
+		v := reflect.New(t.Elem())
+		v.Elem().Set(reflect.ValueOf(prototype).Elem())
+		return v.Interface().(RuntimeObject)
+	}
+}
+
 type WebhookObject struct {
-	WK             *webhook.Server
-	Webhook        RuntimeObject
-	Obj            runtime.Object
+	WK *webhook.Server
+	// Webhook is a RuntimeObject implementing Defaulter and/or Validator,
+	// or any value implementing CustomDefaulter and/or CustomValidator.
+	Webhook interface{}
+	// New returns a fresh RuntimeObject for every admission request.
+	// If it is nil a shallow copy of Webhook is used per request instead.
+	New RuntimeObjectFunc
+	Obj runtime.Object
+	// Scheme finds the GVK of Obj for the paths left empty, the client-go scheme by default.
+	Scheme *runtime.Scheme
+	// ValidatingPath and DefaultingPath are derived from the GVK of Obj when empty,
+	// e.g. /validate-apps-v1-deployment and /mutate-apps-v1-deployment.
 	ValidatingPath string
 	DefaultingPath string
 	Client         client.Client
+	// DenyUnsupported denies operations the validator does not handle instead of allowing them,
+	// e.g. CONNECT when it does not implement ConnectValidator or CustomConnectValidator.
+	DenyUnsupported bool
+	// SubResources holds the webhooks of subresources keyed by name, e.g. "status" or "scale".
+	// Requests for a subresource are routed to its webhook, requests for other subresources
+	// are handled by Webhook.
+	SubResources map[string]SubResource
+	// Defaulters run in order after the defaulter of Webhook on the DefaultingPath,
+	// answering with a single patch, see ChainDefaultingWebhookFor.
+	Defaulters []NamedDefaulter
+	// Validators run after the validator of Webhook on the ValidatingPath, a request is denied
+	// once with the errors of all of them, see AllValidatingWebhookFor.
+	Validators []NamedValidator
+	// ParallelValidators runs Validators in parallel, they must not change the objects, see ParallelValidatingWebhookFor.
+	ParallelValidators bool
+	// Conversion is the conversion webhook of the CRD of Obj served at ConversionPath,
+	// derived from the GVK of Obj by default, e.g. /convert-batch-cuisongliu-com-v2-cron.
+	Conversion     *ConversionWebhook
+	ConversionPath string
+	// Operations are the operations of Obj sent to the webhooks in the generated configurations,
+	// CREATE and UPDATE by default, see WebhookDefinitions.
+	Operations []admissionregistrationv1.OperationType
+	// FailurePolicy is the failurePolicy of the webhooks in the generated configurations, Fail by default.
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+
+	// validating and defaulting tell which webhooks Init registered.
+	validating bool
+	defaulting bool
+}
+
+// SubResource is the webhook of a subresource registered in WebhookObject.SubResources.
+type SubResource struct {
+	// Webhook is a RuntimeObject implementing Defaulter and/or Validator,
+	// or any value implementing CustomDefaulter and/or CustomValidator.
+	Webhook interface{}
+	// New returns a fresh RuntimeObject for every admission request.
+	New RuntimeObjectFunc
+	// Obj is the type of the subresource payload, e.g. *autoscalingv1.Scale for "scale".
+	// If it is nil the type registered in the scheme for the kind of the request is used.
+	Obj runtime.Object
 }
 
-func (wko *WebhookObject) Init() {
-	_ = wko.Webhook.(inject.Client).InjectClient(wko.Client)
-	wko.Webhook.IntoRuntimeObject(wko.Obj)
-	if v,ok:=wko.Webhook.(Validator);ok{
-		wko.WK.Register(wko.ValidatingPath, ValidatingWebhookFor(v))
+// Init registers the webhooks of wko on WK. Empty paths are derived from the GVK of Obj like kubebuilder does,
+// e.g. /validate-autoscaling-v2beta1-horizontalpodautoscaler, and a path already registered on WK is an error.
+func (wko *WebhookObject) Init() error {
+	defaulting, validating := wko.handlers(wko.Webhook, wko.New, wko.Obj)
+	if len(wko.Defaulters) != 0 {
+		defaulting = ChainDefaultingWebhookFor(wko.Obj, wko.chainDefaulters()...).Handler
+	}
+	if len(wko.Validators) != 0 {
+		wh := AllValidatingWebhookFor(wko.Obj, wko.allValidators()...)
+		if wko.ParallelValidators {
+			wh = ParallelValidatingWebhookFor(wko.Obj, wko.allValidators()...)
+		}
+		h := wh.Handler.(*customValidatingHandler)
+		h.denyUnsupported = wko.DenyUnsupported
+		validating = h
+	}
+	if len(wko.SubResources) != 0 {
+		defaultingRouter := subResourceRouter{"": defaulting}
+		validatingRouter := subResourceRouter{"": validating}
+		for name, sub := range wko.SubResources {
+			defaultingRouter[name], validatingRouter[name] = wko.handlers(sub.Webhook, sub.New, sub.Obj)
+		}
+		defaulting, validating = defaultingRouter.handler(), validatingRouter.handler()
+	}
+	var paths []string
+	var hooks []http.Handler
+	if validating != nil {
+		if wko.ValidatingPath == "" {
+			path, err := wko.defaultPath("/validate-")
+			if err != nil {
+				return err
+			}
+			wko.ValidatingPath = path
+		}
+		paths, hooks = append(paths, wko.ValidatingPath), append(hooks, &admission.Webhook{Handler: validating})
 	}
-	if m,ok:=wko.Webhook.(Defaulter);ok{
-		wko.WK.Register(wko.DefaultingPath, DefaultingWebhookFor(m))
+	if defaulting != nil {
+		if wko.DefaultingPath == "" {
+			path, err := wko.defaultPath("/mutate-")
+			if err != nil {
+				return err
+			}
+			wko.DefaultingPath = path
+		}
+		paths, hooks = append(paths, wko.DefaultingPath), append(hooks, &admission.Webhook{Handler: defaulting})
+	}
+	if wko.Conversion != nil {
+		if wko.ConversionPath == "" {
+			path, err := wko.defaultPath("/convert-")
+			if err != nil {
+				return err
+			}
+			wko.ConversionPath = path
+		}
+		paths, hooks = append(paths, wko.ConversionPath), append(hooks, wko.Conversion)
+	}
+	for i, path := range paths {
+		for _, p := range paths[:i] {
+			if p == path {
+				return errors.Errorf("webhook path %s is used twice by the webhooks of %T", path, wko.Obj)
+			}
+		}
+		if wko.registered(path) {
+			return errors.Errorf("webhook path %s is already registered on the webhook server", path)
+		}
+	}
+	for i, path := range paths {
+		wko.WK.Register(path, hooks[i])
+	}
+	wko.validating, wko.defaulting = validating != nil, defaulting != nil
+	register(wko)
+	return nil
+}
+
+// defaultPath returns prefix followed by the group with dashes, the version and the lowercase kind of Obj,
+// like kubebuilder the core group is empty, e.g. /mutate--v1-pod.
+func (wko *WebhookObject) defaultPath(prefix string) (string, error) {
+	if wko.Obj == nil {
+		return "", errors.New("webhook path is empty and Obj is nil")
+	}
+	gvk, err := apiutil.GVKForObject(wko.Obj, schemeOrDefault(wko.Scheme))
+	if err != nil {
+		return "", errors.Errorf("webhook path is empty and find the kind of %T failed %s", wko.Obj, err)
+	}
+	return prefix + strings.ReplaceAll(gvk.Group, ".", "-") + "-" + gvk.Version + "-" + strings.ToLower(gvk.Kind), nil
+}
+
+// registered tells whether a webhook is registered at path on WK.
+func (wko *WebhookObject) registered(path string) bool {
+	if wko.WK.WebhookMux == nil {
+		return false
+	}
+	_, pattern := wko.WK.WebhookMux.Handler(&http.Request{Method: http.MethodPost, URL: &url.URL{Path: path}})
+	return pattern == path
+}
+
+// handlers returns the defaulting and validating handlers of wk, nil for the ones it does not implement.
+func (wko *WebhookObject) handlers(wk interface{}, newFunc RuntimeObjectFunc, obj runtime.Object) (defaulting, validating admission.Handler) {
+	if d, ok := wk.(CustomDefaulter); ok {
+		wko.injectClient(d)
+		defaulting = CustomDefaultingWebhookFor(obj, d).Handler
+	}
+	if v, ok := wk.(CustomValidator); ok {
+		wko.injectClient(v)
+		h := CustomValidatingWebhookFor(obj, v).Handler.(*customValidatingHandler)
+		h.denyUnsupported = wko.DenyUnsupported
+		validating = h
+	}
+	newFunc = wko.newFunc(wk, newFunc, obj)
+	if newFunc == nil {
+		return
+	}
+	sample := newFunc()
+	if _, ok := sample.(Validator); ok {
+		h := ValidatingWebhookForFunc(newFunc).Handler.(*validatingHandler)
+		h.denyUnsupported = wko.DenyUnsupported
+		validating = h
+	}
+	if _, ok := sample.(Defaulter); ok {
+		defaulting = DefaultingWebhookForFunc(newFunc).Handler
+	}
+	return
+}
+
+// chainDefaulters returns the defaulter of Webhook, named "webhook", followed by Defaulters.
+func (wko *WebhookObject) chainDefaulters() []NamedDefaulter {
+	var defaulters []NamedDefaulter
+	if d, ok := wko.Webhook.(CustomDefaulter); ok {
+		defaulters = append(defaulters, NamedDefaulter{Name: "webhook", Defaulter: d})
+	}
+	if newFunc := wko.newFunc(wko.Webhook, wko.New, wko.Obj); newFunc != nil {
+		if _, ok := newFunc().(Defaulter); ok {
+			defaulters = append(defaulters, NamedDefaulter{Name: "webhook", Defaulter: defaulterFunc(func() Defaulter {
+				return newFunc().(Defaulter)
+			})})
+		}
+	}
+	for _, d := range wko.Defaulters {
+		wko.injectClient(d.Defaulter)
+		defaulters = append(defaulters, d)
+	}
+	return defaulters
+}
+
+// allValidators returns the validator of Webhook, named "webhook", followed by Validators.
+func (wko *WebhookObject) allValidators() []NamedValidator {
+	var validators []NamedValidator
+	if v, ok := wko.Webhook.(CustomValidator); ok {
+		validators = append(validators, NamedValidator{Name: "webhook", Validator: v})
+	}
+	if newFunc := wko.newFunc(wko.Webhook, wko.New, wko.Obj); newFunc != nil {
+		if _, ok := newFunc().(Validator); ok {
+			validators = append(validators, NamedValidator{Name: "webhook", Validator: validatorFunc(func() Validator {
+				return newFunc().(Validator)
+			})})
+		}
+	}
+	for _, v := range wko.Validators {
+		wko.injectClient(v.Validator)
+		validators = append(validators, v)
+	}
+	return validators
+}
+
+func (wko *WebhookObject) injectClient(i interface{}) {
+	if c, ok := i.(inject.Client); ok {
+		_ = c.InjectClient(wko.Client)
+	}
+}
+
+func (wko *WebhookObject) newFunc(wk interface{}, newFunc RuntimeObjectFunc, obj runtime.Object) RuntimeObjectFunc {
+	if newFunc == nil {
+		prototype, ok := wk.(RuntimeObject)
+		if !ok {
+			return nil
+		}
+		wko.injectClient(prototype)
+		if obj != nil {
+			prototype.IntoRuntimeObject(obj)
+		}
+		return prototypeFunc(prototype)
+	}
+	return func() RuntimeObject {
+		o := newFunc()
+		wko.injectClient(o)
+		if obj != nil {
+			o.IntoRuntimeObject(obj.DeepCopyObject())
+		}
+		return o
+	}
+}
+
+// subResourceRouter routes admission requests to the handler of their subresource,
+// the handler of the main resource is keyed by "" and handles unknown subresources.
+type subResourceRouter map[string]admission.Handler
+
+// handler returns r without its nil handlers, or nil if all of them are nil.
+func (r subResourceRouter) handler() admission.Handler {
+	for name, h := range r {
+		if h == nil {
+			delete(r, name)
+		}
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Handle handles admission requests.
+func (r subResourceRouter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	h, ok := r[req.SubResource]
+	if !ok {
+		h, ok = r[""]
+	}
+	if !ok {
+		return admission.Allowed("")
+	}
+	return h.Handle(ctx, req)
+}
+
+var _ admission.DecoderInjector = subResourceRouter{}
+
+// InjectDecoder injects the decoder into the handlers of a subResourceRouter.
+func (r subResourceRouter) InjectDecoder(d *admission.Decoder) error {
+	for _, h := range r {
+		if _, err := admission.InjectDecoderInto(d, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InjectFunc injects the field setter into the handlers of a subResourceRouter.
+func (r subResourceRouter) InjectFunc(f inject.Func) error {
+	for _, h := range r {
+		if err := f(h); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,627 @@
+/*
+Copyright © 2021 cuisongliu@qq.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type configMapWebhook struct {
+	object *corev1.ConfigMap
+	client client.Client
+}
+
+func (w *configMapWebhook) OutRuntimeObject() runtime.Object {
+	return w.object
+}
+func (w *configMapWebhook) GetClient() client.Client {
+	return w.client
+}
+func (w *configMapWebhook) IntoRuntimeObject(object runtime.Object) {
+	if obj, ok := object.(*corev1.ConfigMap); ok {
+		w.object = obj
+		return
+	}
+	obj := &corev1.ConfigMap{}
+	_ = JsonConvert(object, obj)
+	w.object = obj
+}
+
+// Default sleeps between reading and writing the object so that a shared
+// instance would be overwritten by a concurrent request.
+func (w *configMapWebhook) Default() {
+	name := w.object.Name
+	time.Sleep(time.Millisecond)
+	w.object.Data = map[string]string{"owner": name}
+}
+func (w *configMapWebhook) ValidateCreate() error {
+	time.Sleep(time.Millisecond)
+	return fmt.Errorf("owner=%s", w.object.Name)
+}
+func (w *configMapWebhook) ValidateUpdate(old runtime.Object) error {
+	time.Sleep(time.Millisecond)
+	return fmt.Errorf("owner=%s", old.(*corev1.ConfigMap).Name)
+}
+func (w *configMapWebhook) ValidateDelete() error {
+	return nil
+}
+
+func newConfigMapRequest(t *testing.T, op admissionv1.Operation, name string) admission.Request {
+	raw, err := json.Marshal(&corev1.ConfigMap{
+		TypeMeta:   v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: op,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	if op == admissionv1.Update {
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+// injectDecoder injects a decoder of the client-go scheme into the handler of wh.
+func injectDecoder(tb testing.TB, wh *admission.Webhook) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := admission.InjectDecoderInto(decoder, wh.Handler); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func runConcurrently(t *testing.T, wh *admission.Webhook, op admissionv1.Operation, check func(name string, resp admission.Response) error) {
+	injectDecoder(t, wh)
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("cm-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := wh.Handler.Handle(context.Background(), newConfigMapRequest(t, op, name))
+			if err := check(name, resp); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMutatingHandler_Concurrent(t *testing.T) {
+	tests := []struct {
+		name string
+		wh   *admission.Webhook
+	}{
+		{name: "prototype", wh: DefaultingWebhookFor(&configMapWebhook{})},
+		{name: "func", wh: DefaultingWebhookForFunc(func() RuntimeObject {
+			return &configMapWebhook{}
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runConcurrently(t, tt.wh, admissionv1.Create, func(name string, resp admission.Response) error {
+				if !resp.Allowed {
+					return fmt.Errorf("%s: not allowed: %v", name, resp.Result)
+				}
+				for _, p := range resp.Patches {
+					if p.Path != "/data" {
+						continue
+					}
+					if owner := p.Value.(map[string]interface{})["owner"]; owner != name {
+						return fmt.Errorf("%s: got patch of %v", name, owner)
+					}
+					return nil
+				}
+				return fmt.Errorf("%s: no /data patch in %v", name, resp.Patches)
+			})
+		})
+	}
+}
+
+func TestValidatingHandler_Concurrent(t *testing.T) {
+	tests := []struct {
+		name string
+		op   admissionv1.Operation
+	}{
+		{name: "create", op: admissionv1.Create},
+		{name: "update", op: admissionv1.Update},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := ValidatingWebhookFor(&configMapWebhook{})
+			runConcurrently(t, wh, tt.op, func(name string, resp admission.Response) error {
+				if want := "owner=" + name; resp.Result == nil || resp.Result.Reason != v1.StatusReason(want) {
+					return fmt.Errorf("%s: got result %v, want %s", name, resp.Result, want)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+type userDefaulter struct{}
+
+func (userDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	req, err := RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	obj.(*corev1.ConfigMap).Data = map[string]string{"user": req.UserInfo.Username}
+	AddWarning(ctx, "data is deprecated")
+	return nil
+}
+
+func TestCustomMutatingHandler_Request(t *testing.T) {
+	wh := CustomDefaultingWebhookFor(&corev1.ConfigMap{}, userDefaulter{})
+	injectDecoder(t, wh)
+	req := newConfigMapRequest(t, admissionv1.Create, "cm")
+	req.UserInfo.Username = "alice"
+	resp := wh.Handler.Handle(context.Background(), req)
+	if !resp.Allowed || len(resp.Patches) != 1 {
+		t.Fatalf("got response %v", resp)
+	}
+	if user := resp.Patches[0].Value.(map[string]interface{})["user"]; user != "alice" {
+		t.Errorf("got user %v, want alice", user)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != "data is deprecated" {
+		t.Errorf("got warnings %v", resp.Warnings)
+	}
+}
+
+type warningWebhook struct {
+	configMapWebhook
+	warnings []string
+}
+
+func (w *warningWebhook) ValidateCreate() error {
+	w.warnings = append(w.warnings, "deprecated "+w.object.Name)
+	return nil
+}
+func (w *warningWebhook) Warnings() []string {
+	return w.warnings
+}
+
+func TestValidatingHandler_Warnings(t *testing.T) {
+	runConcurrently(t, ValidatingWebhookFor(&warningWebhook{}), admissionv1.Create, func(name string, resp admission.Response) error {
+		if !resp.Allowed {
+			return fmt.Errorf("%s: not allowed: %v", name, resp.Result)
+		}
+		if len(resp.Warnings) != 1 || resp.Warnings[0] != "deprecated "+name {
+			return fmt.Errorf("%s: got warnings %v", name, resp.Warnings)
+		}
+		return nil
+	})
+}
+
+type errorValidator struct {
+	err error
+}
+
+func (v errorValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.err
+}
+func (v errorValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return v.err
+}
+func (v errorValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return v.err
+}
+
+func TestCustomValidatingHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   int32
+		wantReason v1.StatusReason
+		wantCauses int
+	}{
+		{name: "plain", err: fmt.Errorf("bad"), wantCode: 403, wantReason: "bad"},
+		{name: "field", err: field.Required(field.NewPath("data"), ""), wantCode: 422, wantReason: v1.StatusReasonInvalid, wantCauses: 1},
+		{name: "list", err: field.ErrorList{
+			field.Required(field.NewPath("data"), ""),
+			field.Invalid(field.NewPath("metadata", "name"), "cm", "bad name"),
+		}.ToAggregate(), wantCode: 422, wantReason: v1.StatusReasonInvalid, wantCauses: 2},
+		{name: "status", err: apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "cm", fmt.Errorf("busy")), wantCode: 409, wantReason: v1.StatusReasonConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := CustomValidatingWebhookFor(&corev1.ConfigMap{}, errorValidator{err: tt.err})
+			injectDecoder(t, wh)
+			resp := wh.Handler.Handle(context.Background(), newConfigMapRequest(t, admissionv1.Create, "cm"))
+			if resp.Allowed {
+				t.Fatal("got allowed")
+			}
+			if resp.Result.Code != tt.wantCode || resp.Result.Reason != tt.wantReason {
+				t.Errorf("got code %d reason %s, want %d %s", resp.Result.Code, resp.Result.Reason, tt.wantCode, tt.wantReason)
+			}
+			var causes int
+			if resp.Result.Details != nil {
+				causes = len(resp.Result.Details.Causes)
+			}
+			if causes != tt.wantCauses {
+				t.Errorf("got %d causes, want %d", causes, tt.wantCauses)
+			}
+		})
+	}
+}
+
+func TestWebhook_AdmissionReviewVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		apiVersion  string
+		wh          *admission.Webhook
+		wantAllowed bool
+		wantPatch   bool
+	}{
+		{name: "mutating v1", apiVersion: "admission.k8s.io/v1", wh: DefaultingWebhookFor(&configMapWebhook{}), wantAllowed: true, wantPatch: true},
+		{name: "mutating v1beta1", apiVersion: "admission.k8s.io/v1beta1", wh: DefaultingWebhookFor(&configMapWebhook{}), wantAllowed: true, wantPatch: true},
+		{name: "validating v1", apiVersion: "admission.k8s.io/v1", wh: ValidatingWebhookFor(&configMapWebhook{})},
+		{name: "validating v1beta1", apiVersion: "admission.k8s.io/v1beta1", wh: ValidatingWebhookFor(&configMapWebhook{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, err := admission.StandaloneWebhook(tt.wh, admission.StandaloneOptions{Scheme: scheme.Scheme})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := newConfigMapRequest(t, admissionv1.Create, "cm")
+			body, err := json.Marshal(map[string]interface{}{
+				"apiVersion": tt.apiVersion,
+				"kind":       "AdmissionReview",
+				"request": map[string]interface{}{
+					"uid":       "0d2b2bd0-3b2e-4f2a-9a0a-1c2d3e4f5a6b",
+					"kind":      v1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+					"resource":  v1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					"operation": req.Operation,
+					"object":    req.Object,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			review := struct {
+				APIVersion string                         `json:"apiVersion"`
+				Kind       string                         `json:"kind"`
+				Response   *admissionv1.AdmissionResponse `json:"response"`
+			}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatal(err)
+			}
+			if review.APIVersion != tt.apiVersion || review.Kind != "AdmissionReview" {
+				t.Errorf("got %s %s, want %s AdmissionReview", review.APIVersion, review.Kind, tt.apiVersion)
+			}
+			if review.Response == nil || review.Response.UID != "0d2b2bd0-3b2e-4f2a-9a0a-1c2d3e4f5a6b" {
+				t.Fatalf("got response %v", review.Response)
+			}
+			if review.Response.Allowed != tt.wantAllowed {
+				t.Errorf("got allowed %v, want %v", review.Response.Allowed, tt.wantAllowed)
+			}
+			if gotPatch := len(review.Response.Patch) != 0; gotPatch != tt.wantPatch {
+				t.Errorf("got patch %s, want patch %v", review.Response.Patch, tt.wantPatch)
+			}
+		})
+	}
+}
+
+type execValidator struct {
+	errorValidator
+}
+
+func (execValidator) ValidateConnect(ctx context.Context, options runtime.Object) error {
+	exec, ok := options.(*corev1.PodExecOptions)
+	if !ok {
+		return fmt.Errorf("got options %T", options)
+	}
+	if exec.Stdin {
+		return fmt.Errorf("stdin is not allowed")
+	}
+	return nil
+}
+
+func TestCustomValidatingHandler_Connect(t *testing.T) {
+	tests := []struct {
+		name            string
+		validator       CustomValidator
+		options         runtime.Object
+		denyUnsupported bool
+		wantAllowed     bool
+	}{
+		{name: "exec allowed", validator: execValidator{}, options: &corev1.PodExecOptions{Command: []string{"ls"}}, wantAllowed: true},
+		{name: "exec denied", validator: execValidator{}, options: &corev1.PodExecOptions{Stdin: true}},
+		{name: "unsupported allowed", validator: errorValidator{}, options: &corev1.PodExecOptions{Stdin: true}, wantAllowed: true},
+		{name: "unsupported denied", validator: errorValidator{}, options: &corev1.PodExecOptions{}, denyUnsupported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := CustomValidatingWebhookFor(&corev1.Pod{}, tt.validator)
+			wh.Handler.(*customValidatingHandler).denyUnsupported = tt.denyUnsupported
+			injectDecoder(t, wh)
+			tt.options.GetObjectKind().SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodExecOptions"))
+			raw, _ := json.Marshal(tt.options)
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Connect,
+				Resource:    v1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				SubResource: "exec",
+				Object:      runtime.RawExtension{Raw: raw},
+			}}
+			resp := wh.Handler.Handle(context.Background(), req)
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("got allowed %v, want %v: %v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+		})
+	}
+}
+
+type legacyExecValidator struct {
+	configMapWebhook
+}
+
+func (*legacyExecValidator) ValidateConnect(options runtime.Object) error {
+	exec, ok := options.(*corev1.PodExecOptions)
+	if !ok {
+		return fmt.Errorf("got options %T", options)
+	}
+	if exec.Stdin {
+		return fmt.Errorf("stdin is not allowed")
+	}
+	return nil
+}
+
+func TestValidatingHandler_Connect(t *testing.T) {
+	tests := []struct {
+		name            string
+		validator       Validator
+		options         runtime.Object
+		denyUnsupported bool
+		wantAllowed     bool
+		wantReason      v1.StatusReason
+	}{
+		{name: "exec allowed", validator: &legacyExecValidator{}, options: &corev1.PodExecOptions{Command: []string{"ls"}}, wantAllowed: true},
+		{name: "exec denied", validator: &legacyExecValidator{}, options: &corev1.PodExecOptions{Stdin: true}, wantReason: "stdin is not allowed"},
+		{name: "exec denied with unsupported denied", validator: &legacyExecValidator{}, options: &corev1.PodExecOptions{Stdin: true},
+			denyUnsupported: true, wantReason: "stdin is not allowed"},
+		{name: "unsupported allowed", validator: &configMapWebhook{}, options: &corev1.PodExecOptions{Stdin: true}, wantAllowed: true},
+		{name: "unsupported denied", validator: &configMapWebhook{}, options: &corev1.PodExecOptions{}, denyUnsupported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := ValidatingWebhookFor(tt.validator)
+			wh.Handler.(*validatingHandler).denyUnsupported = tt.denyUnsupported
+			injectDecoder(t, wh)
+			tt.options.GetObjectKind().SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodExecOptions"))
+			raw, _ := json.Marshal(tt.options)
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Connect,
+				Resource:    v1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				SubResource: "exec",
+				Object:      runtime.RawExtension{Raw: raw},
+			}}
+			resp := wh.Handler.Handle(context.Background(), req)
+			if resp.Allowed != tt.wantAllowed {
+				t.Fatalf("got allowed %v, want %v: %v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+			if tt.wantReason != "" && resp.Result.Reason != tt.wantReason {
+				t.Errorf("got reason %s, want %s", resp.Result.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func benchmarkValidatingHandler(b *testing.B, object runtime.Object) {
+	wh := ValidatingWebhookFor(&warningWebhook{})
+	wh.Handler.(*validatingHandler).object = object
+	injectDecoder(b, wh)
+	cm := &corev1.ConfigMap{
+		TypeMeta:   v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: v1.ObjectMeta{Name: "cm", Namespace: "default", Labels: map[string]string{"app": "bench"}},
+		Data:       map[string]string{},
+	}
+	for i := 0; i < 100; i++ {
+		cm.Data[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("value-%d", i)
+	}
+	raw, _ := json.Marshal(cm)
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp := wh.Handler.Handle(context.Background(), req); !resp.Allowed {
+			b.Fatal(resp.Result)
+		}
+	}
+}
+
+// BenchmarkValidatingHandler_Typed decodes straight into the registered type.
+func BenchmarkValidatingHandler_Typed(b *testing.B) {
+	benchmarkValidatingHandler(b, &corev1.ConfigMap{})
+}
+
+// BenchmarkValidatingHandler_Unstructured decodes into unstructured and converts it with JsonConvert.
+func BenchmarkValidatingHandler_Unstructured(b *testing.B) {
+	benchmarkValidatingHandler(b, &unstructured.Unstructured{})
+}
+
+func TestValidatingHandler_DecodeError(t *testing.T) {
+	wh := ValidatingWebhookFor(&configMapWebhook{})
+	injectDecoder(t, wh)
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","data":1}`)},
+	}}
+	resp := wh.Handler.Handle(context.Background(), req)
+	if resp.Allowed || resp.Result.Code != http.StatusBadRequest {
+		t.Errorf("got response %v, want code %d", resp.Result, http.StatusBadRequest)
+	}
+}
+
+type scaleValidator struct {
+	errorValidator
+}
+
+func (scaleValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	if scale := newObj.(*autoscalingv1.Scale); scale.Spec.Replicas > 10 {
+		return fmt.Errorf("replicas %d is more than 10", scale.Spec.Replicas)
+	}
+	return nil
+}
+
+func TestWebhookObject_SubResources(t *testing.T) {
+	server := &webhook.Server{}
+	wko := &WebhookObject{
+		WK:             server,
+		Webhook:        errorValidator{},
+		Obj:            &appsv1.Deployment{},
+		ValidatingPath: "/validate-apps-v1-deployment",
+		SubResources: map[string]SubResource{
+			"scale": {Webhook: scaleValidator{}},
+		},
+	}
+	if err := wko.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.InjectFunc(func(i interface{}) error {
+		_, err := inject.SchemeInto(scheme.Scheme, i)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		subResource string
+		kind        v1.GroupVersionKind
+		object      runtime.Object
+		wantAllowed bool
+	}{
+		{name: "deployment", kind: v1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, object: &appsv1.Deployment{
+			TypeMeta: v1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		}, wantAllowed: true},
+		{name: "scale allowed", subResource: "scale", kind: v1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"}, object: &autoscalingv1.Scale{
+			TypeMeta: v1.TypeMeta{APIVersion: "autoscaling/v1", Kind: "Scale"},
+			Spec:     autoscalingv1.ScaleSpec{Replicas: 3},
+		}, wantAllowed: true},
+		{name: "scale denied", subResource: "scale", kind: v1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"}, object: &autoscalingv1.Scale{
+			TypeMeta: v1.TypeMeta{APIVersion: "autoscaling/v1", Kind: "Scale"},
+			Spec:     autoscalingv1.ScaleSpec{Replicas: 20},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(tt.object)
+			body, _ := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: v1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:         "uid",
+					Kind:        tt.kind,
+					Resource:    v1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+					SubResource: tt.subResource,
+					Operation:   admissionv1.Update,
+					Object:      runtime.RawExtension{Raw: raw},
+					OldObject:   runtime.RawExtension{Raw: raw},
+				},
+			})
+			r := httptest.NewRequest(http.MethodPost, wko.ValidatingPath, bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			server.WebhookMux.ServeHTTP(w, r)
+
+			review := admissionv1.AdmissionReview{}
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatal(err)
+			}
+			if review.Response == nil || review.Response.Allowed != tt.wantAllowed {
+				t.Errorf("got response %v, want allowed %v", review.Response, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestWebhookObject_DefaultPaths(t *testing.T) {
+	server := &webhook.Server{}
+	configMaps := &WebhookObject{WK: server, Webhook: &configMapWebhook{}, Obj: &corev1.ConfigMap{}}
+	if err := configMaps.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if configMaps.ValidatingPath != "/validate--v1-configmap" || configMaps.DefaultingPath != "/mutate--v1-configmap" {
+		t.Errorf("got paths %s and %s", configMaps.ValidatingPath, configMaps.DefaultingPath)
+	}
+	deployments := &WebhookObject{WK: server, Webhook: errorValidator{}, Obj: &appsv1.Deployment{}}
+	if err := deployments.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if deployments.ValidatingPath != "/validate-apps-v1-deployment" || deployments.DefaultingPath != "" {
+		t.Errorf("got paths %s and %s", deployments.ValidatingPath, deployments.DefaultingPath)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/validate-apps-v1-deployment", nil)
+	if _, pattern := server.WebhookMux.Handler(r); pattern != "/validate-apps-v1-deployment" {
+		t.Errorf("got pattern %s", pattern)
+	}
+
+	again := &WebhookObject{WK: server, Webhook: errorValidator{}, Obj: &appsv1.Deployment{}}
+	if err := again.Init(); err == nil || err.Error() != "webhook path /validate-apps-v1-deployment is already registered on the webhook server" {
+		t.Errorf("got error %v", err)
+	}
+	twice := &WebhookObject{WK: &webhook.Server{}, Webhook: &configMapWebhook{}, Obj: &corev1.ConfigMap{},
+		ValidatingPath: "/configmap", DefaultingPath: "/configmap"}
+	if err := twice.Init(); err == nil {
+		t.Error("the same path was registered twice")
+	}
+	untyped := &WebhookObject{WK: &webhook.Server{}, Webhook: errorValidator{}}
+	if err := untyped.Init(); err == nil {
+		t.Error("a path was derived without Obj")
+	}
+}